@@ -1,13 +1,25 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
 type accessFuncT func(bucket string, o *s3.ObjectVersion) error
 
+// errSkipped is returned by access functions for versions that don't match the selection.
+var errSkipped = errors.New("skipped")
+
+// errDeclined is returned by access functions for versions the user declined to change. They are counted as
+// skipped, but their page isn't journaled, so a resumed run asks again.
+var errDeclined = errors.New("declined")
+
 func accessFuncBuilder(op accessFuncT) accessFuncT {
 	switch {
 	case accessConfig.all:
@@ -19,32 +31,107 @@ func accessFuncBuilder(op accessFuncT) accessFuncT {
 			if o.VersionId != nil && *o.VersionId == accessConfig.version {
 				return op(bucket, o)
 			}
-			return nil
+			return errSkipped
 		}
 	default: // use latest
 		return func(bucket string, o *s3.ObjectVersion) error {
-			if o.IsLatest != nil && *o.IsLatest {
+			// IsLatest is unknown for versions named in a --from-file manifest that doesn't say, those are processed as requested
+			if o.IsLatest == nil || *o.IsLatest {
 				return op(bucket, o)
 			}
-			return nil
+			return errSkipped
 		}
 	}
 }
 
-func run(urls []string, holdFunc accessFuncT) error {
-	svc := getS3()
+// runStats counts versions handled by run.
+type runStats struct {
+	processed uint64
+	skipped   uint64
+	failed    uint64
+	untouched uint64
+}
+
+func (s *runStats) add(other *runStats) {
+	s.processed += other.processed
+	s.skipped += other.skipped
+	s.failed += other.failed
+	s.untouched += other.untouched
+}
+
+// runSource is a URL ( or the --from-file manifest ) processed by run with its own accounting.
+type runSource struct {
+	url     string
+	stats   runStats
+	listErr error
+}
+
+// run applies holdFunc to the versions found under urls. The urls are listed concurrently,
+// up to --workers at a time, and may belong to different buckets.
+// Once ctx is cancelled listing stops and the versions that were not handed to holdFunc yet are left untouched,
+// while the calls that are already in flight are allowed to complete.
+// With --keep-going the errors returned by holdFunc are collected and reported at the end,
+// and a URL that can't be listed doesn't stop the others.
+// With --checkpoint the listing pages that were fully processed are recorded in the journal,
+// so a rerun continues from where the previous one stopped.
+// With --from-file the versions are read from the manifest instead of listing urls.
+// With --shard-depth the listing is sharded and the journal records the pages of every shard.
+func run(ctx context.Context, urls []string, holdFunc accessFuncT) error {
+	lim := getLimiter()
 
 	type Batch struct {
 		bucket  string
 		objects []*s3.ObjectVersion
+		page    journalEntry
+		source  *runSource
+	}
+
+	urls, err := expandURLs(ctx, urls)
+	if err != nil {
+		return err
+	}
+	var sources []*runSource
+	if manifestConf.path != "" {
+		sources = append(sources, &runSource{url: manifestConf.path})
+	}
+	for _, url := range urls {
+		if _, _, err := fromS3(url); err != nil {
+			return err
+		}
+		sources = append(sources, &runSource{url: url})
+	}
+
+	var journal *checkpoint
+	switch {
+	case checkpointConf.path == "":
+	case globalOpts.dryRun:
+		// the pages of a dry run aren't processed, recording them would make the real run skip them
+		log.Infof("--checkpoint %s is ignored with --dry-run", checkpointConf.path)
+	default:
+		var err error
+		if journal, err = openCheckpoint(checkpointConf.path, checkpointConf.header); err != nil {
+			return err
+		}
+		defer journal.close()
 	}
 
+	// listing stops when ctx is cancelled or, without --keep-going, when a URL can't be listed
+	listCtx, stopListing := context.WithCancel(ctx)
+	defer stopListing()
+
+	// without --keep-going the first failure stops processing, the calls in flight complete
+	procCtx, stopProcessing := context.WithCancel(ctx)
+	defer stopProcessing()
+
 	var (
 		batchChan = make(chan Batch)
 		wg        sync.WaitGroup
+		failed    failures
+		failOnce  sync.Once
+		failErr   error
 	)
 
-	log.Debugf("Sarting %d workers", globalOpts.workers)
+	log.Debugf("Starting %d workers", globalOpts.workers)
 	wg.Add(globalOpts.workers)
 
 	for i := 0; i < globalOpts.workers; i++ {
@@ -52,10 +139,50 @@ func run(urls []string, holdFunc accessFuncT) error {
 			defer wg.Done()
 			for batch := range batchChan {
 				log.Debugf("New batch: %+v", batch)
+				stats := &batch.source.stats
+				complete := true
 				for _, o := range batch.objects {
+					if procCtx.Err() != nil {
+						atomic.AddUint64(&stats.untouched, 1)
+						complete = false
+						continue
+					}
 					log.Debugf("Processing s3://%s/%s", batch.bucket, *o.Key)
-					if err := holdFunc(batch.bucket, o); err != nil {
-						log.Fatalf("Can't process s3://%s/%s : %+v", batch.bucket, *o.Key, err)
+					err := lim.do(procCtx, func() error { return holdFunc(batch.bucket, o) })
+					atomic.AddUint64(&progress.processed, 1)
+					switch err {
+					case nil:
+						atomic.AddUint64(&stats.processed, 1)
+					case errSkipped:
+						atomic.AddUint64(&stats.skipped, 1)
+					case errDeclined:
+						atomic.AddUint64(&stats.skipped, 1)
+						complete = false
+					case procCtx.Err():
+						// stopped by a failure before the call was made
+						atomic.AddUint64(&stats.untouched, 1)
+						complete = false
+					default:
+						complete = false
+						atomic.AddUint64(&stats.failed, 1)
+						atomic.AddUint64(&progress.errors, 1)
+						if !globalOpts.keepGoing {
+							if procCtx.Err() == nil {
+								failOnce.Do(func() {
+									failErr = fmt.Errorf("can't process s3://%s/%s: %v", batch.bucket, *o.Key, err)
+									stopProcessing()
+									stopListing()
+								})
+							}
+							continue
+						}
+						log.Debugf("Can't process s3://%s/%s : %+v", batch.bucket, *o.Key, err)
+						failed.add(batch.bucket, *o.Key, aws.StringValue(o.VersionId), err)
+					}
+				}
+				if journal != nil && complete {
+					if err := journal.pageDone(batch.page); err != nil {
+						log.Errorf("can't write checkpoint %s: %v", checkpointConf.path, err)
 					}
 				}
 			}
@@ -63,30 +190,166 @@ func run(urls []string, holdFunc accessFuncT) error {
 		}()
 	}
 
-	for _, url := range urls {
-		bucket, prefix, err := fromS3(url)
-		if err != nil {
-			return err
+	send := func(batch Batch) bool {
+		if journal != nil && journal.isDone(batch.page) {
+			log.Debugf("Skipping batch %d of %s", batch.page.Page, batch.page.URL)
+			return true
 		}
-		if err := svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		select {
+		case batchChan <- batch:
+			log.Debug("Sending batch")
+			return true
+		case <-listCtx.Done():
+			atomic.AddUint64(&batch.source.stats.untouched, uint64(len(batch.objects)))
+			return false
+		}
+	}
+
+	listManifest := func(source *runSource) error {
+		page := 0
+		return readManifestBatches(func(bucket string, versions []*s3.ObjectVersion) bool {
+			batch := Batch{
+				bucket:  bucket,
+				objects: versions,
+				page: journalEntry{
+					URL:             source.url,
+					Page:            page,
+					KeyMarker:       bucket + "/" + *versions[0].Key,
+					VersionIdMarker: aws.StringValue(versions[0].VersionId),
+				},
+				source: source,
+			}
+			page++
+			atomic.AddUint64(&progress.listed, uint64(len(versions)))
+			return send(batch)
+		})
+	}
+
+	listURL := func(source *runSource) error {
+		url := source.url
+		bucket, prefix, _ := fromS3(url)
+		input := &s3.ListObjectVersionsInput{
 			Bucket: &bucket,
 			Prefix: &prefix,
-		}, func(res *s3.ListObjectVersionsOutput, last bool) bool {
-			batchChan <- Batch{
+		}
+		var (
+			page int
+			// pages of every shard when the listing is sharded, see walkVersions
+			shardPages = make(map[string]int)
+		)
+		if journal != nil && listingConf.shardDepth <= 0 {
+			var (
+				keyMarker, versionIdMarker string
+				complete                   bool
+			)
+			page, keyMarker, versionIdMarker, complete = journal.resume(url)
+			if complete {
+				log.Infof("%s is complete according to %s", url, checkpointConf.path)
+				return nil
+			}
+			if keyMarker != "" {
+				log.Infof("resuming %s from %s", url, keyMarker)
+				input.KeyMarker = aws.String(keyMarker)
+			}
+			if versionIdMarker != "" {
+				input.VersionIdMarker = aws.String(versionIdMarker)
+			}
+		}
+		return walkVersions(listCtx, input, func(shard string, res *s3.ListObjectVersionsOutput, last bool) bool {
+			journalURL, journalPage := url, page
+			if shard != "" {
+				journalURL, journalPage = url+" "+shard, shardPages[shard]
+				shardPages[shard]++
+			}
+			batch := Batch{
 				bucket:  bucket,
 				objects: res.Versions,
+				page: journalEntry{
+					URL:                 journalURL,
+					Page:                journalPage,
+					KeyMarker:           aws.StringValue(res.KeyMarker),
+					VersionIdMarker:     aws.StringValue(res.VersionIdMarker),
+					NextKeyMarker:       aws.StringValue(res.NextKeyMarker),
+					NextVersionIdMarker: aws.StringValue(res.NextVersionIdMarker),
+					Last:                last,
+				},
+				source: source,
 			}
-			log.Debug("Sending batch")
-			return true
-		}); err != nil {
-			log.Errorf("can't list objects at %s: %v", url, err)
-			close(batchChan)
-			return err
-		}
-		close(batchChan)
+			page++
+			return send(batch)
+		})
+	}
+
+	var (
+		listers   sync.WaitGroup
+		listSlots = make(chan struct{}, globalOpts.workers)
+	)
+	for _, source := range sources {
+		listers.Add(1)
+		listSlots <- struct{}{}
+		go func(source *runSource) {
+			defer func() {
+				<-listSlots
+				listers.Done()
+			}()
+			list := listURL
+			if manifestConf.path != "" && source.url == manifestConf.path {
+				list = listManifest
+			}
+			if err := list(source); err != nil && listCtx.Err() == nil {
+				log.Errorf("can't list objects at %s: %v", source.url, err)
+				source.listErr = err
+				if !globalOpts.keepGoing {
+					stopListing()
+				}
+			}
+		}(source)
 	}
+	listers.Wait()
+	close(batchChan)
+	listingDone()
 	log.Debug("Done")
 	wg.Wait()
+
+	var (
+		total    runStats
+		listErrs []error
+	)
+	for _, source := range sources {
+		total.add(&source.stats)
+		if source.listErr != nil {
+			listErrs = append(listErrs, source.listErr)
+		}
+		if len(sources) > 1 {
+			summary := fmt.Sprintf("%s: %d version(s) processed, %d skipped, %d failed",
+				source.url, source.stats.processed, source.stats.skipped, source.stats.failed)
+			switch {
+			case source.listErr != nil:
+				log.Warnf("%s, listing failed: %v", summary, source.listErr)
+			case source.stats.untouched > 0:
+				log.Warnf("%s, %d listed but left untouched", summary, source.stats.untouched)
+			default:
+				log.Info(summary)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		log.Warnf("interrupted: %d version(s) processed, %d skipped, %d failed, %d listed but left untouched",
+			total.processed, total.skipped, total.failed, total.untouched)
+	} else {
+		log.Debugf("%d version(s) processed, %d skipped, %d failed", total.processed, total.skipped, total.failed)
+	}
+	reportErr := failed.report()
+	switch {
+	case failErr != nil:
+		return failErr
+	case len(listErrs) == 1:
+		return listErrs[0]
+	case len(listErrs) > 1:
+		return fmt.Errorf("can't list %d of %d URL(s)", len(listErrs), len(sources))
+	case ctx.Err() != nil:
+		return ctx.Err()
+	}
 	log.Debug("Complete")
-	return nil
+	return reportErr
 }
@@ -1,26 +1,103 @@
 package cmd
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
 	"sync"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/spf13/pflag"
 )
 
 var (
 	once sync.Once
-	svc  *s3.S3
+	svc  s3iface.S3API
 	sess *session.Session
 )
 
-func _init() {
-	sess = session.Must(session.NewSessionWithOptions(session.Options{
+// sessionOpts configure the AWS session, which makes s3kit usable with S3-compatible storages.
+var sessionOpts struct {
+	endpoint       string
+	profile        string
+	region         string
+	forcePathStyle bool
+	noVerifySSL    bool
+	// headers set on every bucket request, see bucketHeaders
+	requestPayer        string
+	expectedBucketOwner string
+}
+
+var accountId = regexp.MustCompile(`^\d{12}$`)
+
+// prepareSession validates --request-payer and --expected-bucket-owner.
+func prepareSession() error {
+	switch sessionOpts.requestPayer {
+	case "", s3.RequestPayerRequester:
+	default:
+		return fmt.Errorf("unknown request payer '%s', expected '%s'", sessionOpts.requestPayer, s3.RequestPayerRequester)
+	}
+	if o := sessionOpts.expectedBucketOwner; o != "" && !accountId.MatchString(o) {
+		return fmt.Errorf("expected bucket owner '%s' is not a 12 digit AWS account id", o)
+	}
+	return nil
+}
+
+// bucketHeaders is a request.Handlers.Build handler that sets --request-payer and --expected-bucket-owner
+// on every request of the session, including the ones made by the parquet reader, whatever input struct they come from.
+func bucketHeaders(r *request.Request) {
+	if r.Operation.Name == "ListBuckets" {
+		// not a bucket request
+		return
+	}
+	if sessionOpts.requestPayer != "" {
+		r.HTTPRequest.Header.Set("X-Amz-Request-Payer", sessionOpts.requestPayer)
+	}
+	if sessionOpts.expectedBucketOwner != "" {
+		r.HTTPRequest.Header.Set("X-Amz-Expected-Bucket-Owner", sessionOpts.expectedBucketOwner)
+	}
+}
+
+// newSession builds the session from the flags, the settings the flags leave unset come from the environment
+// and the shared config of --profile.
+func newSession() (*session.Session, error) {
+	cfg := aws.NewConfig()
+	if sessionOpts.region != "" {
+		cfg.WithRegion(sessionOpts.region)
+	}
+	if sessionOpts.endpoint != "" {
+		cfg.WithEndpoint(sessionOpts.endpoint)
+	}
+	if sessionOpts.forcePathStyle {
+		cfg.WithS3ForcePathStyle(true)
+	}
+	if sessionOpts.noVerifySSL {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		cfg.WithHTTPClient(&http.Client{Transport: transport})
+	}
+	return session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		Profile:           sessionOpts.profile,
 		SharedConfigState: session.SharedConfigEnable,
-	}))
+	})
+}
+
+func _init() {
+	sess = session.Must(newSession())
+	apiCalls.addHandlers(&sess.Handlers)
+	sess.Handlers.Build.PushBack(bucketHeaders)
 	svc = s3.New(sess)
 }
 
-func getS3() *s3.S3 {
+func getS3() s3iface.S3API {
 	once.Do(_init)
 	return svc
 }
@@ -29,3 +106,29 @@ func getSession() *session.Session {
 	once.Do(_init)
 	return sess
 }
+
+// useS3 replaces the client returned by getS3, e.g. with the in-memory backend from s3mem.
+func useS3(api s3iface.S3API) {
+	once.Do(func() {})
+	svc = api
+}
+
+func envBool(name string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(name))
+	return v
+}
+
+// initSessionFlags registers the session flags, their defaults come from the S3KIT_* environment variables.
+func initSessionFlags(pf *pflag.FlagSet) {
+	pf.StringVar(&sessionOpts.endpoint, "endpoint-url", os.Getenv("S3KIT_ENDPOINT_URL"), "S3 endpoint URL, e.g. MinIO or a VPC endpoint ( $S3KIT_ENDPOINT_URL )")
+	pf.StringVar(&sessionOpts.profile, "profile", os.Getenv("S3KIT_PROFILE"), "AWS shared config profile ( $S3KIT_PROFILE )")
+	pf.StringVar(&sessionOpts.region, "region", os.Getenv("S3KIT_REGION"), "AWS region ( $S3KIT_REGION )")
+	pf.BoolVar(&sessionOpts.forcePathStyle, "force-path-style", envBool("S3KIT_FORCE_PATH_STYLE"), "use path-style bucket addressing ( $S3KIT_FORCE_PATH_STYLE )")
+	pf.BoolVar(&sessionOpts.noVerifySSL, "no-verify-ssl", envBool("S3KIT_NO_VERIFY_SSL"), "don't verify TLS certificates ( $S3KIT_NO_VERIFY_SSL )")
+	pf.StringVar(&sessionOpts.requestPayer, "request-payer", os.Getenv("S3KIT_REQUEST_PAYER"), "set to 'requester' to access Requester Pays buckets ( $S3KIT_REQUEST_PAYER )")
+	pf.StringVar(&sessionOpts.expectedBucketOwner, "expected-bucket-owner", os.Getenv("S3KIT_EXPECTED_BUCKET_OWNER"), "fail the requests to buckets not owned by the AWS account id ( $S3KIT_EXPECTED_BUCKET_OWNER )")
+}
+
+func init() {
+	initSessionFlags(rootCmd.PersistentFlags())
+}
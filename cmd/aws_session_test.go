@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketHeaders(t *testing.T) {
+	headers := make(map[string]http.Header)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers[r.URL.Path] = r.Header
+		w.Write([]byte(`<ListAllMyBucketsResult/>`))
+	}))
+	defer srv.Close()
+	defer func() { sessionOpts.requestPayer, sessionOpts.expectedBucketOwner = "", "" }()
+
+	sessionOpts.requestPayer, sessionOpts.expectedBucketOwner = "requester", "123"
+	require.Error(t, prepareSession())
+	sessionOpts.expectedBucketOwner = "123456789012"
+	require.NoError(t, prepareSession())
+
+	sess := session.Must(session.NewSession(aws.NewConfig().
+		WithEndpoint(srv.URL).
+		WithRegion("us-east-1").
+		WithS3ForcePathStyle(true).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", ""))))
+	sess.Handlers.Build.PushBack(bucketHeaders)
+	api := s3.New(sess)
+	_, err := api.GetObjectTagging(&s3.GetObjectTaggingInput{Bucket: aws.String("bucket"), Key: aws.String("key")})
+	require.NoError(t, err)
+	_, err = api.ListBuckets(&s3.ListBucketsInput{})
+	require.NoError(t, err)
+
+	require.Equal(t, "requester", headers["/bucket/key"].Get("X-Amz-Request-Payer"))
+	require.Equal(t, "123456789012", headers["/bucket/key"].Get("X-Amz-Expected-Bucket-Owner"))
+	require.Contains(t, headers["/bucket/key"].Get("Authorization"), "x-amz-request-payer", "the header is signed")
+	require.Empty(t, headers["/"].Get("X-Amz-Request-Payer"))
+}
+
+func TestSessionFlags(t *testing.T) {
+	config, err := ioutil.TempFile("", "s3kit-config")
+	require.NoError(t, err)
+	defer os.Remove(config.Name())
+	_, err = config.WriteString("[profile minio]\nregion = ap-south-1\n")
+	require.NoError(t, err)
+	require.NoError(t, config.Close())
+
+	saved := sessionOpts
+	defer func() { sessionOpts = saved }()
+	env := map[string]string{
+		"AWS_CONFIG_FILE":        config.Name(),
+		"AWS_REGION":             "",
+		"AWS_DEFAULT_REGION":     "",
+		"S3KIT_ENDPOINT_URL":     "http://env:9000",
+		"S3KIT_PROFILE":          "minio",
+		"S3KIT_REGION":           "",
+		"S3KIT_FORCE_PATH_STYLE": "true",
+	}
+	for name, value := range env {
+		old, ok := os.LookupEnv(name)
+		require.NoError(t, os.Setenv(name, value))
+		defer func(name, old string, ok bool) {
+			if ok {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		}(name, old, ok)
+	}
+
+	resolve := func(args ...string) *aws.Config {
+		f := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		initSessionFlags(f)
+		require.NoError(t, f.Parse(args))
+		s, err := newSession()
+		require.NoError(t, err)
+		return s.Config
+	}
+
+	cfg := resolve()
+	require.Equal(t, "http://env:9000", aws.StringValue(cfg.Endpoint))
+	require.Equal(t, "ap-south-1", aws.StringValue(cfg.Region), "the region of the profile")
+	require.True(t, aws.BoolValue(cfg.S3ForcePathStyle))
+
+	cfg = resolve("--endpoint-url", "http://flag:9000", "--region", "eu-west-1", "--force-path-style=false")
+	require.Equal(t, "http://flag:9000", aws.StringValue(cfg.Endpoint), "flags take precedence over the environment")
+	require.Equal(t, "eu-west-1", aws.StringValue(cfg.Region), "flags take precedence over the profile")
+	require.False(t, aws.BoolValue(cfg.S3ForcePathStyle))
+
+	require.NoError(t, os.Setenv("S3KIT_REGION", "us-west-2"))
+	cfg = resolve()
+	require.Equal(t, "us-west-2", aws.StringValue(cfg.Region), "the environment takes precedence over the profile")
+}
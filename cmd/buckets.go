@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// isBucketGlob tells if the bucket of an S3 URL is a pattern, bucket names can't contain these characters.
+func isBucketGlob(bucket string) bool {
+	return strings.ContainsAny(bucket, "*?[")
+}
+
+// sessionRegion returns the region of the S3 client, empty if it isn't known.
+func sessionRegion() string {
+	if sessionOpts.region != "" {
+		return sessionOpts.region
+	}
+	if sess != nil {
+		return aws.StringValue(sess.Config.Region)
+	}
+	return ""
+}
+
+// expandURLs replaces every URL with a bucket glob, e.g. s3://logs-*/2020/ or s3://*/, with the URLs of the
+// matching buckets. The buckets come from ListBuckets, made once, and unless --endpoint-url is set only the
+// buckets in the region of the session match, the client can't reach the others.
+func expandURLs(ctx context.Context, urls []string) ([]string, error) {
+	var (
+		expanded = make([]string, 0, len(urls))
+		buckets  []string
+		lim      = getLimiter()
+		regions  = make(map[string]string)
+	)
+	for _, url := range urls {
+		bucket, _, err := fromS3(url)
+		if err != nil {
+			return nil, err
+		}
+		if !isBucketGlob(bucket) {
+			expanded = append(expanded, url)
+			continue
+		}
+		if _, err := path.Match(bucket, ""); err != nil {
+			return nil, fmt.Errorf("invalid bucket pattern in %s: %v", url, err)
+		}
+		if buckets == nil {
+			var res *s3.ListBucketsOutput
+			if err := lim.do(ctx, func() (err error) {
+				res, err = getS3().ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+				return err
+			}); err != nil {
+				return nil, fmt.Errorf("can't list buckets: %v", err)
+			}
+			buckets = make([]string, 0, len(res.Buckets))
+			for _, b := range res.Buckets {
+				buckets = append(buckets, aws.StringValue(b.Name))
+			}
+		}
+		// the path as it's written in the URL
+		rest := url[strings.Index(url, "://")+len("://")+len(bucket):]
+		matched := 0
+		for _, name := range buckets {
+			if ok, _ := path.Match(bucket, name); !ok {
+				continue
+			}
+			if region := sessionRegion(); region != "" && sessionOpts.endpoint == "" {
+				bucketRegion, ok := regions[name]
+				if !ok {
+					var res *s3.GetBucketLocationOutput
+					if err := lim.do(ctx, func() (err error) {
+						res, err = getS3().GetBucketLocationWithContext(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(name)})
+						return err
+					}); err != nil {
+						return nil, fmt.Errorf("can't get the region of bucket %s: %v", name, err)
+					}
+					bucketRegion = s3.NormalizeBucketLocation(aws.StringValue(res.LocationConstraint))
+					regions[name] = bucketRegion
+				}
+				if bucketRegion != region {
+					log.Debugf("skipping bucket %s in %s", name, bucketRegion)
+					continue
+				}
+			}
+			expanded = append(expanded, "s3://"+name+rest)
+			matched++
+		}
+		if matched == 0 {
+			return nil, fmt.Errorf("no bucket matches %s", url)
+		}
+		log.Debugf("%s matches %d bucket(s)", url, matched)
+	}
+	return expanded, nil
+}
@@ -1,74 +1,328 @@
 package cmd
 
 import (
-	"compress/bzip2"
-	"compress/gzip"
-	"io"
+	"context"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var catCmd = &cobra.Command{
 	Use:          "cat s3://bucket/key1 s3://bucket/prefix/ ...",
 	Short:        "Print content of S3 file(s) to stdout",
-	Args:         cobra.MinimumNArgs(1),
+	Annotations:  map[string]string{progressAnnotation: progressStdout},
+	Args:         urlArgs,
 	SilenceUsage: true,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		svc := getS3()
-		for _, url := range args {
-			bucket, prefix, err := fromS3(url)
-			if err != nil {
-				return err
+	PreRunE: func(*cobra.Command, []string) error {
+		return prepareCat()
+	},
+	RunE: runCat,
+}
+
+func runCat(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	args, err := expandURLs(ctx, args)
+	if err != nil {
+		return err
+	}
+	if err := prepareVersions(args); err != nil {
+		return err
+	}
+	q := newCatQueue(ctx, getS3())
+	if manifestConf.path != "" {
+		if err := readManifestBatches(func(bucket string, versions []*s3.ObjectVersion) bool {
+			atomic.AddUint64(&progress.listed, uint64(len(versions)))
+			for _, v := range versions {
+				if !q.add(bucket, v.Key, v.VersionId) {
+					return false
+				}
 			}
-			if err := svc.ListObjectsPages(&s3.ListObjectsInput{
+			return true
+		}); err != nil {
+			q.close()
+			return err
+		}
+		return q.close()
+	}
+	for _, url := range args {
+		bucket, prefix, err := fromS3(url)
+		if err != nil {
+			q.close()
+			return err
+		}
+		switch {
+		case catConf.versionId != "":
+			q.add(bucket, aws.String(prefix), aws.String(catConf.versionId))
+		case !catConf.asOf.IsZero():
+			err = walkAsOf(q.ctx, bucket, prefix, catConf.asOf.Time, func(key, versionId *string) bool {
+				return q.add(bucket, key, versionId)
+			})
+		default:
+			err = walkObjects(q.ctx, &s3.ListObjectsV2Input{
 				Bucket: &bucket,
 				Prefix: &prefix,
-			}, func(res *s3.ListObjectsOutput, last bool) bool {
+			}, func(res *s3.ListObjectsV2Output, last bool) bool {
 				for _, o := range res.Contents {
-					val, err := svc.GetObject(&s3.GetObjectInput{
-						Bucket: &bucket,
-						Key:    o.Key,
-					})
-					if err != nil {
+					if !q.add(bucket, o.Key, nil) {
 						return false
 					}
-					if err := func() error {
-						defer val.Body.Close()
-						var reader io.Reader
-						switch {
-						case strings.HasSuffix(*o.Key, ".gz") || strings.HasSuffix(*o.Key, ".gzip"):
-							if r, err := gzip.NewReader(val.Body); err != nil {
-								reader = val.Body
-							} else {
-								reader = r
-							}
-						case strings.HasSuffix(*o.Key, ".bz2"):
-							if r := bzip2.NewReader(val.Body); err != nil {
-								reader = val.Body
-							} else {
-								reader = r
-							}
-						default:
-							reader = val.Body
-						}
-						_, err := io.Copy(os.Stdout, reader)
-						return err
-					}(); err != nil {
-						panic(err)
-					}
 				}
 				return true
-			}); err != nil {
+			})
+		}
+		if err != nil && q.ctx.Err() == nil {
+			q.close()
+			return err
+		}
+	}
+	if err := q.close(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// catChunkSize is the size of the reads of the object content, see catQueue.
+const catChunkSize = 64 * 1024
+
+// catRecordsAhead is the number of records buffered for every object read ahead with --decode.
+const catRecordsAhead = 1024
+
+var catConf = struct {
+	readAhead  int
+	bufferSize flagSize
+	unordered  bool
+	codec      string
+	byteRange  string
+	headLines  int
+	tailLines  int
+	withKey    bool
+	versionId  string
+	asOf       flagTime
+	decode     bool
+	limit      int
+	columns    []string
+}{
+	bufferSize: flagSize{size: 8 * 1000 * 1000, set: true},
+	codec:      codecAuto,
+}
+
+// catJob is an object printed by cat, its content comes in chunks from the worker that fetches it.
+type catJob struct {
+	bucket    string
+	key       *string
+	versionId *string
+	chunks    chan []byte
+	// the decoded records instead of the chunks with --decode
+	records chan fields
+	// the columns of the records, set before the first record is sent
+	columns []string
+	// set before chunks is closed
+	err error
+	// sent to catQueue.ready, with --unordered
+	ready bool
+}
+
+// catQueue fetches and decompresses the objects added to it with --workers, up to --read-ahead objects ahead
+// of the one printed, and prints them to stdout in the order they were added, or as they come with --unordered.
+// The content of an object is buffered up to --read-ahead-size, then its worker waits for it to be printed,
+// so the memory is bounded by --read-ahead times --read-ahead-size.
+type catQueue struct {
+	ctx     context.Context
+	cancel  func()
+	svc     s3iface.S3API
+	slots   chan struct{}
+	work    chan *catJob
+	ordered chan *catJob
+	// the jobs with some content or done, with --unordered
+	ready   chan *catJob
+	workers sync.WaitGroup
+	done    chan error
+	// the renderer of the records with --decode and its columns, used by print
+	decoded *renderer
+	columns []string
+}
+
+func newCatQueue(ctx context.Context, svc s3iface.S3API) *catQueue {
+	readAhead := catConf.readAhead
+	if readAhead <= 0 {
+		readAhead = globalOpts.workers
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	q := &catQueue{
+		ctx:     ctx,
+		cancel:  cancel,
+		svc:     svc,
+		slots:   make(chan struct{}, readAhead),
+		work:    make(chan *catJob),
+		ordered: make(chan *catJob, readAhead),
+		ready:   make(chan *catJob, readAhead),
+		done:    make(chan error, 1),
+	}
+	q.workers.Add(globalOpts.workers)
+	for i := 0; i < globalOpts.workers; i++ {
+		go func() {
+			defer q.workers.Done()
+			for job := range q.work {
+				job.err = q.fetch(job)
+				if job.err != nil && q.ctx.Err() == nil {
+					atomic.AddUint64(&progress.errors, 1)
+				}
+				close(job.chunks)
+				if job.records != nil {
+					close(job.records)
+				}
+				q.markReady(job)
+			}
+		}()
+	}
+	go q.print()
+	return q
+}
+
+// markReady sends the job to q.ready once it has some content or is done, with --unordered.
+func (q *catQueue) markReady(job *catJob) {
+	if catConf.unordered && !job.ready {
+		job.ready = true
+		// never blocks, there are as many slots
+		q.ready <- job
+	}
+}
+
+// add queues the object to print, the latest version if versionId is nil. It blocks while --read-ahead objects
+// wait to be printed and returns false once printing failed or the context is done.
+func (q *catQueue) add(bucket string, key, versionId *string) bool {
+	select {
+	case q.slots <- struct{}{}:
+	case <-q.ctx.Done():
+		return false
+	}
+	chunks := catConf.bufferSize.size / catChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	job := &catJob{
+		bucket:    bucket,
+		key:       key,
+		versionId: versionId,
+		chunks:    make(chan []byte, chunks),
+	}
+	if catConf.decode {
+		job.records = make(chan fields, catRecordsAhead)
+	}
+	if !catConf.unordered {
+		// never blocks, there are as many slots
+		q.ordered <- job
+	}
+	select {
+	case q.work <- job:
+		return true
+	case <-q.ctx.Done():
+		return false
+	}
+}
+
+// close waits for the queued objects to be printed and returns the first error.
+func (q *catQueue) close() error {
+	close(q.work)
+	q.workers.Wait()
+	close(q.ordered)
+	close(q.ready)
+	err := <-q.done
+	q.cancel()
+	return err
+}
+
+// fetch sends the decompressed content of the object to its chunks.
+func (q *catQueue) fetch(job *catJob) error {
+	if err := q.ctx.Err(); err != nil {
+		return err
+	}
+	w := &catWriter{q: q, job: job}
+	var err error
+	switch {
+	case catConf.byteRange != "":
+		err = q.copyRange(job, w, keyPrefix(job))
+	case catConf.decode:
+		err = q.copyDecoded(job)
+	case catConf.tailLines > 0:
+		err = q.copyTail(job, w, keyPrefix(job))
+	default:
+		err = q.copyObject(job, w, keyPrefix(job))
+	}
+	if err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// print writes the content of the jobs to stdout in order, and releases their slots.
+func (q *catQueue) print() {
+	jobs := q.ordered
+	if catConf.unordered {
+		jobs = q.ready
+	}
+	var err error
+	for job := range jobs {
+		if err == nil {
+			if err = q.write(job); err != nil {
+				q.cancel()
+			}
+		}
+		<-q.slots
+	}
+	if q.decoded != nil && err == nil {
+		err = q.decoded.close()
+	}
+	q.done <- err
+}
+
+func (q *catQueue) write(job *catJob) error {
+	if job.records != nil {
+		return q.writeRecords(job)
+	}
+	for {
+		select {
+		case chunk, ok := <-job.chunks:
+			if !ok {
+				atomic.AddUint64(&progress.processed, 1)
+				return job.err
+			}
+			if _, err := os.Stdout.Write(chunk); err != nil {
 				return err
 			}
+		case <-q.ctx.Done():
+			return q.ctx.Err()
 		}
-		return nil
-	},
+	}
+}
+
+// initCatFlags registers the flags cat shares with head.
+func initCatFlags(f *pflag.FlagSet) {
+	f.IntVar(&catConf.readAhead, "read-ahead", 0, "objects fetched and decompressed ahead of the one printed, --workers by default")
+	f.Var(&catConf.bufferSize, "read-ahead-size", "content buffered for every object read ahead ( 1024, 10MB, 1GiB )")
+	f.BoolVar(&catConf.unordered, "unordered", false, "print the objects as they are fetched instead of in the key order")
+	f.StringVar(&catConf.codec, "codec", codecAuto, "decompress the objects with the codec ( "+strings.Join(codecNames(), " | ")+" ), auto detects it from the content")
+	f.StringVar(&catConf.versionId, "version-id", "", "print the version of the key instead of the latest one")
+	f.Var(&catConf.asOf, "as-of", "print the versions that were current at the time ( YYYY-MM-DD or 2020-04-10T12:00:00Z ), skipping the deleted keys")
+	initRangeFlags(f)
 }
 
 func init() {
+	f := catCmd.Flags()
+	initCatFlags(f)
+	f.StringVar(&catConf.byteRange, "range", "", "print only the bytes of the objects in the range ( 0-1023, 1024-, -1024 ) as they are stored")
+	f.IntVar(&catConf.headLines, "head-lines", 0, "print only the first lines of the objects, fetched with ranged reads")
+	f.IntVar(&catConf.tailLines, "tail-lines", 0, "print only the last lines of the objects, read backwards unless compressed")
+	f.BoolVar(&catConf.withKey, "with-key", false, "prefix every line with the S3 URL of its object")
+	f.BoolVar(&catConf.decode, "decode", false, "print the records of Parquet, Avro and JSON Lines objects as ndjson, or --output csv / tsv")
+	f.IntVar(&catConf.limit, "limit", 0, "print at most that many records of every object with --decode, 0 is no limit")
+	f.StringSliceVar(&catConf.columns, "columns", nil, "print only the fields of the records with --decode, in the order given")
 	rootCmd.AddCommand(catCmd)
 }
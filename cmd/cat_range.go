@@ -0,0 +1,433 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// the first ranged GET of the line modes, doubled by every next one up to maxRangeSize
+	minRangeSize = 256 * 1024
+	maxRangeSize = 16 * 1024 * 1024
+)
+
+var (
+	byteRange    = regexp.MustCompile(`^(\d+-\d*|-\d+)$`)
+	contentRange = regexp.MustCompile(`^bytes \d+-\d+/(\d+)$`)
+)
+
+// prepareCat validates the cat flags.
+func prepareCat() error {
+	if err := prepareCodec(); err != nil {
+		return err
+	}
+	if catConf.byteRange != "" {
+		if !byteRange.MatchString(catConf.byteRange) {
+			return fmt.Errorf("invalid --range '%s', expected first-last, first- or -suffix bytes", catConf.byteRange)
+		}
+		if catConf.headLines > 0 || catConf.tailLines > 0 {
+			return fmt.Errorf("--range can't be used with --head-lines or --tail-lines")
+		}
+	}
+	if catConf.headLines > 0 && catConf.tailLines > 0 {
+		return fmt.Errorf("--head-lines can't be used with --tail-lines")
+	}
+	return prepareDecode()
+}
+
+// isInvalidRange is true for the error of a range starting past the end of the object, an empty object included.
+func isInvalidRange(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "InvalidRange"
+}
+
+// objectSize returns the total size from the content range of a ranged GET, false if the range was ignored.
+func objectSize(res *s3.GetObjectOutput) (int64, bool) {
+	m := contentRange.FindStringSubmatch(aws.StringValue(res.ContentRange))
+	if m == nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(m[1], 10, 64)
+	return size, err == nil
+}
+
+// getRange fetches the bytes of the object in the HTTP range spec, such as 0-99 or -100.
+func (q *catQueue) getRange(job *catJob, spec string) (*s3.GetObjectOutput, error) {
+	atomic.AddUint64(&progress.requests, 1)
+	return q.svc.GetObjectWithContext(q.ctx, &s3.GetObjectInput{
+		Bucket:    &job.bucket,
+		Key:       job.key,
+		VersionId: job.versionId,
+		Range:     aws.String("bytes=" + spec),
+	})
+}
+
+// readRange returns the bytes of the object in the range spec and the object size, nothing for a range
+// past the end of the object.
+func (q *catQueue) readRange(job *catJob, spec string) ([]byte, int64, error) {
+	res, err := q.getRange(job, spec)
+	if isInvalidRange(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(countingReader{res.Body})
+	if err != nil {
+		return nil, 0, readError(job, err)
+	}
+	size, ok := objectSize(res)
+	if !ok {
+		// the whole object
+		size = int64(len(data))
+	}
+	return data, size, nil
+}
+
+// rangeReader reads an object with ranged GETs of growing size, so a reader that stops early
+// fetches little more than it has read.
+type rangeReader struct {
+	q      *catQueue
+	job    *catJob
+	offset int64
+	// the size of the next GET
+	next int64
+	body io.ReadCloser
+	// set once the body ends with the object
+	last bool
+}
+
+func newRangeReader(q *catQueue, job *catJob) *rangeReader {
+	return &rangeReader{q: q, job: job, next: minRangeSize}
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if r.last {
+				return 0, io.EOF
+			}
+			res, err := r.q.getRange(r.job, fmt.Sprintf("%d-%d", r.offset, r.offset+r.next-1))
+			if isInvalidRange(err) {
+				return 0, io.EOF
+			}
+			if err != nil {
+				return 0, err
+			}
+			size, ok := objectSize(res)
+			r.body, r.last = res.Body, !ok || r.offset+r.next >= size
+			if r.next < maxRangeSize {
+				r.next *= 2
+			}
+		}
+		n, err := countingReader{r.body}.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF {
+			r.body.Close()
+			r.body = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *rangeReader) Close() error {
+	if r.body != nil {
+		return r.body.Close()
+	}
+	return nil
+}
+
+// catWriter sends what is written to the chunks of the job, in chunks of catChunkSize.
+type catWriter struct {
+	q   *catQueue
+	job *catJob
+	buf []byte
+}
+
+func (w *catWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, catChunkSize)
+		}
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf, p = w.buf[:len(w.buf)+n], p[n:]
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush sends the buffered content.
+func (w *catWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	w.q.markReady(w.job)
+	select {
+	case w.job.chunks <- w.buf:
+		w.buf = nil
+		return nil
+	case <-w.q.ctx.Done():
+		return w.q.ctx.Err()
+	}
+}
+
+// objectError is an error of reading the content of an object.
+type objectError struct {
+	url string
+	err error
+}
+
+func (e *objectError) Error() string {
+	return fmt.Sprintf("can't read %s: %v", e.url, e.err)
+}
+
+// readError wraps the errors of reading the content of the object, unless they are wrapped already.
+func readError(job *catJob, err error) error {
+	if _, ok := err.(*objectError); ok {
+		return err
+	}
+	return &objectError{url: fmt.Sprintf("s3://%s/%s", job.bucket, *job.key), err: err}
+}
+
+// errorReader wraps the read errors of the object, see readError.
+type errorReader struct {
+	r   io.Reader
+	job *catJob
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = readError(r.job, err)
+	}
+	return n, err
+}
+
+// copyLines copies the first n lines of r to w, all of them if n is zero, each one prefixed with prefix.
+func copyLines(w io.Writer, r io.Reader, n int, prefix string) error {
+	if n <= 0 && prefix == "" {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	br := bufio.NewReaderSize(r, catChunkSize)
+	lineStart := true
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			if lineStart && prefix != "" {
+				if _, err := io.WriteString(w, prefix); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			lineStart = line[len(line)-1] == '\n'
+			if lineStart && n > 0 {
+				if n--; n == 0 {
+					return nil
+				}
+			}
+		}
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			if !lineStart && prefix != "" {
+				// the prefixed lines of the next object start on a line of their own
+				_, err = io.WriteString(w, "\n")
+				return err
+			}
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// lastLines returns the index in data where its last n lines start, -1 if it has fewer lines.
+func lastLines(data []byte, n int) int {
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for ; n > 0; n-- {
+		i := bytes.LastIndexByte(data[:end], '\n')
+		if i < 0 {
+			return -1
+		}
+		end = i
+	}
+	return end + 1
+}
+
+// tailLines copies the last n lines of r to w, keeping only them in memory.
+func tailLines(w io.Writer, r io.Reader, n int, prefix string) error {
+	br := bufio.NewReaderSize(r, catChunkSize)
+	ring := make([][]byte, n)
+	lines := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			ring[lines%n] = line
+			lines++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for i := lines - n; i < lines; i++ {
+		if i >= 0 {
+			buf.Write(ring[i%n])
+		}
+	}
+	return copyLines(w, &buf, 0, prefix)
+}
+
+// copyObject prints the object, its first --head-lines with ranged GETs.
+func (q *catQueue) copyObject(job *catJob, w io.Writer, prefix string) error {
+	var (
+		body    io.ReadCloser
+		content io.Reader
+	)
+	if catConf.headLines > 0 {
+		r := newRangeReader(q, job)
+		body, content = r, r
+	} else {
+		atomic.AddUint64(&progress.requests, 1)
+		val, err := q.svc.GetObjectWithContext(q.ctx, &s3.GetObjectInput{
+			Bucket:    &job.bucket,
+			Key:       job.key,
+			VersionId: job.versionId,
+		})
+		if err != nil {
+			return err
+		}
+		body, content = val.Body, countingReader{val.Body}
+	}
+	defer body.Close()
+	reader, err := decompress(*job.key, errorReader{content, job})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return copyLines(w, errorReader{reader, job}, catConf.headLines, prefix)
+}
+
+// copyRange prints the --range bytes of the object as they are stored, nothing if it starts past the end.
+func (q *catQueue) copyRange(job *catJob, w io.Writer, prefix string) error {
+	res, err := q.getRange(job, catConf.byteRange)
+	if isInvalidRange(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return copyLines(w, errorReader{countingReader{res.Body}, job}, 0, prefix)
+}
+
+// copyTail prints the last --tail-lines of the object, reading it backwards with ranged GETs up to
+// --read-ahead-size. A compressed object, or one whose lines don't fit, is read from the start instead.
+func (q *catQueue) copyTail(job *catJob, w io.Writer, prefix string) error {
+	head, size, err := q.readRange(job, fmt.Sprintf("0-%d", magicSize-1))
+	if err != nil {
+		return err
+	}
+	if selectCodec(*job.key, head) != nil {
+		return q.streamTail(job, w, prefix, true)
+	}
+	data := head
+	if size > int64(len(head)) {
+		// the parts read backwards, the last one starts the content
+		var (
+			parts [][]byte
+			start = size
+			read  uint64
+			lines int
+		)
+		for chunk := int64(minRangeSize); start > 0 && lines < catConf.tailLines; {
+			if read >= catConf.bufferSize.size {
+				return q.streamTail(job, w, prefix, false)
+			}
+			from := start - chunk
+			if left := int64(catConf.bufferSize.size - read); chunk > left {
+				from = start - left
+			}
+			if from < 0 {
+				from = 0
+			}
+			part, _, err := q.readRange(job, fmt.Sprintf("%d-%d", from, start-1))
+			if err != nil {
+				return err
+			}
+			end := len(part)
+			if start == size && end > 0 && part[end-1] == '\n' {
+				// the line break ending the object doesn't start a line
+				end--
+			}
+			parts = append(parts, part)
+			lines += bytes.Count(part[:end], []byte{'\n'})
+			start, read = from, read+uint64(len(part))
+			if chunk < maxRangeSize {
+				chunk *= 2
+			}
+		}
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+		data = bytes.Join(parts, nil)
+	}
+	if i := lastLines(data, catConf.tailLines); i > 0 {
+		data = data[i:]
+	}
+	return copyLines(w, bytes.NewReader(data), 0, prefix)
+}
+
+// streamTail prints the last --tail-lines of the object read from the start, decompressed if compressed is set.
+func (q *catQueue) streamTail(job *catJob, w io.Writer, prefix string, compressed bool) error {
+	body := newRangeReader(q, job)
+	defer body.Close()
+	var content io.Reader = errorReader{body, job}
+	if compressed {
+		reader, err := decompress(*job.key, content)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		content = errorReader{reader, job}
+	}
+	return tailLines(w, content, catConf.tailLines, prefix)
+}
+
+// keyPrefix is the prefix of the lines of the object with --with-key.
+func keyPrefix(job *catJob) string {
+	if !catConf.withKey {
+		return ""
+	}
+	return strings.Join([]string{"s3://", job.bucket, "/", *job.key, ":"}, "")
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// asOfEntry is a version or a delete marker of a key.
+type asOfEntry struct {
+	key          *string
+	versionId    *string
+	lastModified time.Time
+	size         int64
+	deleted      bool
+}
+
+// walkAsOf calls fn with the version of every key under prefix that was current at asOf, in the key order
+// of every shard. The keys that didn't exist at asOf, or whose newest entry at asOf is a delete marker, are skipped.
+// The current version is resolved from all the versions and markers, then the key filters and the age and size
+// predicates apply to it. --start-after and --max-keys apply to the keys passed to fn.
+func walkAsOf(ctx context.Context, bucket, prefix string, asOf time.Time, fn func(key, versionId *string) bool) error {
+	var (
+		// the last key of every shard that was resolved, its older versions are ignored
+		resolved  = make(map[string]string)
+		remaining = listingConf.maxKeys
+	)
+	return walkVersionPages(ctx, &s3.ListObjectVersionsInput{
+		Bucket: &bucket,
+		Prefix: &prefix,
+	}, nil, func(shard string, res *s3.ListObjectVersionsOutput, last bool) bool {
+		entries := make([]asOfEntry, 0, len(res.Versions)+len(res.DeleteMarkers))
+		for _, v := range res.Versions {
+			entries = append(entries, asOfEntry{key: v.Key, versionId: v.VersionId, lastModified: aws.TimeValue(v.LastModified), size: aws.Int64Value(v.Size)})
+		}
+		for _, m := range res.DeleteMarkers {
+			entries = append(entries, asOfEntry{key: m.Key, versionId: m.VersionId, lastModified: aws.TimeValue(m.LastModified), deleted: true})
+		}
+		// the versions and the markers of a key are listed newest first, but separately
+		sort.SliceStable(entries, func(i, j int) bool {
+			if c := strings.Compare(*entries[i].key, *entries[j].key); c != 0 {
+				return c < 0
+			}
+			return entries[i].lastModified.After(entries[j].lastModified)
+		})
+		for _, e := range entries {
+			if last, ok := resolved[shard]; (ok && last == *e.key) || e.lastModified.After(asOf) {
+				continue
+			}
+			resolved[shard] = *e.key
+			if e.deleted || *e.key <= listingConf.startAfter || !matchObject(*e.key, e.lastModified, e.size) {
+				continue
+			}
+			if !fn(e.key, e.versionId) {
+				return false
+			}
+			if remaining--; remaining == 0 {
+				log.Infof("stopped listing s3://%s/%s at --max-keys %d, the last key is %s", bucket, prefix, listingConf.maxKeys, *e.key)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// prepareVersions validates --version-id and --as-of of cat.
+func prepareVersions(args []string) error {
+	if catConf.versionId == "" && catConf.asOf.IsZero() {
+		return nil
+	}
+	if manifestConf.path != "" {
+		return fmt.Errorf("--version-id and --as-of can't be used with --from-file")
+	}
+	if catConf.asOf.IsZero() {
+		if len(args) != 1 || strings.HasSuffix(args[0], "/") {
+			return fmt.Errorf("--version-id needs exactly one key, got %s", strings.Join(args, " "))
+		}
+		return nil
+	}
+	if catConf.versionId != "" {
+		return fmt.Errorf("--version-id can't be used with --as-of")
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var checkpointConf struct {
+	path   string
+	header journalHeader
+}
+
+// journalHeader is the first line of the checkpoint file, the journal is resumed only by the same command
+// with the same arguments and selection, otherwise its pages were processed with other settings.
+type journalHeader struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Flags   map[string]string `json:"flags"`
+}
+
+func (h journalHeader) String() string {
+	cmd := append([]string{h.Command}, h.Args...)
+	names := make([]string, 0, len(h.Flags))
+	for name := range h.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd = append(cmd, fmt.Sprintf("--%s=%s", name, h.Flags[name]))
+	}
+	return strings.Join(cmd, " ")
+}
+
+// checkpointIgnoredFlags don't change what a command does to the objects it selects.
+var checkpointIgnoredFlags = map[string]bool{
+	"checkpoint": true, "debug": true, "quiet": true, "dry-run": true, "help": true, "workers": true,
+	"keep-going": true, "failures-format": true, "retry-budget": true, "stats": true, "price-table": true,
+	"output": true, "template": true, "shard-depth": true, "shard-delimiter": true,
+	"endpoint-url": true, "profile": true, "region": true, "force-path-style": true, "no-verify-ssl": true,
+	"request-payer": true, "expected-bucket-owner": true,
+}
+
+// prepareCheckpoint records the command, its arguments and the flags selecting the objects for the journal header.
+func prepareCheckpoint(cmd *cobra.Command, args []string) {
+	h := journalHeader{Command: cmd.CommandPath(), Args: args, Flags: make(map[string]string)}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !checkpointIgnoredFlags[f.Name] {
+			h.Flags[f.Name] = f.Value.String()
+		}
+	})
+	checkpointConf.header = h
+}
+
+func initCheckpointConfig(f *pflag.FlagSet) {
+	f.StringVar(&checkpointConf.path, "checkpoint", "", "journal file to resume an interrupted run from")
+}
+
+// journalEntry is a line of the checkpoint file, it records a listing page whose versions were all processed.
+// A page is identified by its number and the markers it was listed from, so a page that was
+// completed out of order is recognized when the listing is repeated from an earlier marker.
+type journalEntry struct {
+	URL                 string `json:"url"`
+	Page                int    `json:"page"`
+	KeyMarker           string `json:"key_marker,omitempty"`
+	VersionIdMarker     string `json:"version_id_marker,omitempty"`
+	NextKeyMarker       string `json:"next_key_marker,omitempty"`
+	NextVersionIdMarker string `json:"next_version_id_marker,omitempty"`
+	Last                bool   `json:"last,omitempty"`
+}
+
+// checkpoint is an append-only journal of processed listing pages.
+type checkpoint struct {
+	mu    sync.Mutex
+	file  *os.File
+	pages map[string]map[int]journalEntry
+}
+
+// openCheckpoint opens the journal, the one of another command or selection than header is refused.
+func openCheckpoint(path string, header journalHeader) (*checkpoint, error) {
+	c := &checkpoint{pages: make(map[string]map[int]journalEntry)}
+	empty := true
+	if f, err := os.Open(path); err == nil {
+		lines := bufio.NewScanner(f)
+		if lines.Scan() {
+			empty = false
+			var first struct {
+				Header *journalHeader `json:"header"`
+			}
+			if err := json.Unmarshal(lines.Bytes(), &first); err != nil || first.Header == nil {
+				f.Close()
+				return nil, fmt.Errorf("%s has no checkpoint header, it can't be resumed by '%s'", path, header)
+			}
+			if first.Header.String() != header.String() {
+				f.Close()
+				return nil, fmt.Errorf("checkpoint %s was written by '%s', it can't be resumed by '%s'", path, first.Header, header)
+			}
+		}
+		for lines.Scan() {
+			var e journalEntry
+			if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+				// the last line may be cut if the process was killed while writing it
+				log.Warnf("skipping malformed checkpoint entry '%s': %v", lines.Text(), err)
+				continue
+			}
+			c.add(e)
+		}
+		f.Close()
+		if err := lines.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.file = f
+	if empty {
+		data, err := json.Marshal(struct {
+			Header journalHeader `json:"header"`
+		}{header})
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *checkpoint) add(e journalEntry) {
+	pages, ok := c.pages[e.URL]
+	if !ok {
+		pages = make(map[int]journalEntry)
+		c.pages[e.URL] = pages
+	}
+	pages[e.Page] = e
+}
+
+// resume returns the page to continue the listing of url from and its markers.
+// The url is complete if all of its pages were processed.
+func (c *checkpoint) resume(url string) (page int, keyMarker, versionIdMarker string, complete bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pages := c.pages[url]
+	for {
+		e, ok := pages[page]
+		if !ok {
+			return
+		}
+		if e.Last {
+			return page + 1, "", "", true
+		}
+		page, keyMarker, versionIdMarker = page+1, e.NextKeyMarker, e.NextVersionIdMarker
+	}
+}
+
+// isDone tells if the page was processed by a previous run.
+func (c *checkpoint) isDone(e journalEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	done, ok := c.pages[e.URL][e.Page]
+	return ok && done.KeyMarker == e.KeyMarker && done.VersionIdMarker == e.VersionIdMarker
+}
+
+// pageDone appends the page to the journal.
+func (c *checkpoint) pageDone(e journalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(e)
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return c.file.Sync()
+}
+
+func (c *checkpoint) close() error {
+	return c.file.Close()
+}
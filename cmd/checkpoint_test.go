@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	c := &checkpoint{pages: make(map[string]map[int]journalEntry)}
+	for _, e := range []journalEntry{
+		{URL: "s3://b/", Page: 0, NextKeyMarker: "k1", NextVersionIdMarker: "v1"},
+		{URL: "s3://b/", Page: 1, KeyMarker: "k1", VersionIdMarker: "v1", NextKeyMarker: "k2", NextVersionIdMarker: "v2"},
+		{URL: "s3://b/", Page: 3, KeyMarker: "k3", VersionIdMarker: "v3", Last: true},
+	} {
+		c.add(e)
+	}
+	page, keyMarker, versionIdMarker, complete := c.resume("s3://b/")
+	require.Equal(t, 2, page)
+	require.Equal(t, "k2", keyMarker)
+	require.Equal(t, "v2", versionIdMarker)
+	require.False(t, complete)
+	require.True(t, c.isDone(journalEntry{URL: "s3://b/", Page: 3, KeyMarker: "k3", VersionIdMarker: "v3"}))
+	require.False(t, c.isDone(journalEntry{URL: "s3://b/", Page: 3, KeyMarker: "k4"}))
+
+	c.add(journalEntry{URL: "s3://b/", Page: 2, KeyMarker: "k2", VersionIdMarker: "v2", NextKeyMarker: "k3", NextVersionIdMarker: "v3"})
+	_, _, _, complete = c.resume("s3://b/")
+	require.True(t, complete)
+}
+
+func TestCheckpointRerun(t *testing.T) {
+	newBackend(t)
+	dir, err := ioutil.TempDir("", "s3kit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	checkpointConf.path = filepath.Join(dir, "journal")
+	defer func() { checkpointConf.path = "" }()
+
+	processed := func(result error) int {
+		var n int32
+		require.NoError(t, run(context.Background(), []string{"s3://bucket/data/"}, func(string, *s3.ObjectVersion) error {
+			atomic.AddInt32(&n, 1)
+			return result
+		}))
+		return int(n)
+	}
+	require.Equal(t, 3, processed(errDeclined))
+	require.Equal(t, 3, processed(nil), "the declined versions aren't journaled")
+	require.Zero(t, processed(nil))
+}
+
+func TestCheckpointHeader(t *testing.T) {
+	b := newBackend(t)
+	dir, err := ioutil.TempDir("", "s3kit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	journal := filepath.Join(dir, "journal")
+
+	require.NoError(t, execute("lock", "legal", "add", "s3://bucket/data/", "--dry-run", "--checkpoint", journal))
+	_, err = os.Stat(journal)
+	require.True(t, os.IsNotExist(err), "a dry run doesn't write the journal")
+
+	require.NoError(t, execute("lock", "legal", "add", "s3://bucket/data/", "--checkpoint", journal))
+	res, err := b.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{Bucket: aws.String("bucket"), Key: aws.String("data/b")})
+	require.NoError(t, err)
+	require.Equal(t, s3.ObjectLockLegalHoldStatusOn, *res.LegalHold.Status)
+	require.NoError(t, execute("lock", "legal", "add", "s3://bucket/data/", "--checkpoint", journal), "the same run resumes")
+
+	for _, args := range [][]string{
+		{"lock", "legal", "rm", "s3://bucket/data/"},
+		{"lock", "legal", "add", "s3://bucket/data/a"},
+		{"lock", "legal", "add", "s3://bucket/data/", "--all"},
+	} {
+		err := execute(append(args, "--checkpoint", journal)...)
+		require.Error(t, err, "%v", args)
+		require.Contains(t, err.Error(), "can't be resumed")
+	}
+}
+
+func TestComplianceDeclined(t *testing.T) {
+	b := newBackend(t)
+	complianceConf.duration = time.Hour
+	defer func() { complianceConf.duration = 0 }()
+	v := &s3.ObjectVersion{Key: aws.String("data/b"), VersionId: aws.String(versionIds(t, b, "data/b")[0])}
+	for _, answer := range []string{"n\n", ""} {
+		op := complOp(b, bufio.NewReader(strings.NewReader(answer)))
+		out := captureStdout(t, func() {
+			require.Equal(t, errDeclined, op("bucket", v), "answer %q", answer)
+		})
+		require.Contains(t, out, "proceed? (y/N)")
+	}
+}
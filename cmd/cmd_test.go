@@ -0,0 +1,500 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jdevelop/s3kit/s3mem"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func init() {
+	// replaced by rootCmd.PersistentPreRunE for the tests that execute commands
+	log = zap.NewNop().Sugar()
+}
+
+// newBackend creates an object lock enabled "bucket" with two versions of "data/a" and one of "data/b",
+// and makes the commands use it.
+func newBackend(t *testing.T) *s3mem.Backend {
+	b := s3mem.New()
+	_, err := b.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String("bucket"),
+		ObjectLockEnabledForBucket: aws.Bool(true),
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	for _, k := range []string{"data/a", "data/a", "data/b"} {
+		_, err := b.Put("bucket", k, []byte(k), now)
+		require.NoError(t, err)
+	}
+	useS3(b)
+	return b
+}
+
+func execute(args ...string) error {
+	accessConfig.version, accessConfig.latest, accessConfig.all = "", true, false
+	globalOpts.keepGoing, globalOpts.dryRun = false, false
+	manifestConf.path, manifestConf.inventory, checkpointConf.path = "", false, ""
+	outputConf.format, outputConf.template = "", ""
+	lsConfig.sortBy, lsConfig.reverse, lsConfig.group = "", false, false
+	lsConfig.asJson, lsConfig.asYaml, lsConfig.asTable = false, false, false
+	catConf.readAhead, catConf.unordered, catConf.codec = 0, false, codecAuto
+	catConf.byteRange, catConf.headLines, catConf.tailLines, catConf.withKey = "", 0, 0, false
+	headConf.lines, headConf.noKey = 10, false
+	catConf.versionId, catConf.asOf = "", flagTime{}
+	catConf.decode, catConf.limit, catConf.columns = false, 0, nil
+	listingConf.startAfter, listingConf.maxKeys = "", 0
+	rootCmd.SetArgs(args)
+	return rootCmd.Execute()
+}
+
+// captureStdout returns what f printed to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- data
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+func versionIds(t *testing.T, b *s3mem.Backend, key string) []string {
+	var ids []string
+	require.NoError(t, b.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String("bucket"),
+		Prefix: aws.String(key),
+	}, func(res *s3.ListObjectVersionsOutput, _ bool) bool {
+		for _, v := range res.Versions {
+			ids = append(ids, *v.VersionId)
+		}
+		return true
+	}))
+	return ids
+}
+
+func tagsOf(t *testing.T, b *s3mem.Backend, key, version string) map[string]string {
+	res, err := b.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket:    aws.String("bucket"),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	})
+	require.NoError(t, err)
+	tags := make(map[string]string)
+	for _, t := range res.TagSet {
+		tags[*t.Key] = *t.Value
+	}
+	return tags
+}
+
+func TestTagAddRm(t *testing.T) {
+	b := newBackend(t)
+	require.NoError(t, execute("tag", "add", "s3://bucket/data/", "--tags", "a=1,b=2"))
+	ids := versionIds(t, b, "data/a")
+	require.Equal(t, map[string]string{"a": "1", "b": "2"}, tagsOf(t, b, "data/a", ids[0]))
+	require.Empty(t, tagsOf(t, b, "data/a", ids[1]), "only the latest version is tagged by default")
+
+	require.NoError(t, execute("tag", "rm", "s3://bucket/data/a", "--tags", "a", "--all"))
+	require.Equal(t, map[string]string{"b": "2"}, tagsOf(t, b, "data/a", ids[0]))
+}
+
+func TestLocks(t *testing.T) {
+	b := newBackend(t)
+	ids := versionIds(t, b, "data/a")
+	require.NoError(t, execute("lock", "legal", "add", "s3://bucket/data/a", "--version", ids[1]))
+	hold, err := b.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket:    aws.String("bucket"),
+		Key:       aws.String("data/a"),
+		VersionId: aws.String(ids[1]),
+	})
+	require.NoError(t, err)
+	require.Equal(t, s3.ObjectLockLegalHoldStatusOn, *hold.LegalHold.Status)
+
+	require.NoError(t, execute("lock", "governance", "add", "s3://bucket/data/b", "--expire", "1h"))
+	ret, err := b.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("data/b"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, s3.ObjectLockRetentionModeGovernance, *ret.Retention.Mode)
+	require.True(t, ret.Retention.RetainUntilDate.After(time.Now().Add(59*time.Minute)))
+}
+
+func TestRunCancelled(t *testing.T) {
+	newBackend(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var processed int
+	err := run(ctx, []string{"s3://bucket/data/"}, func(string, *s3.ObjectVersion) error {
+		processed++
+		return nil
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Zero(t, processed)
+}
+
+func TestKeepGoing(t *testing.T) {
+	b := newBackend(t)
+	_, err := b.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("plain")})
+	require.NoError(t, err)
+	_, err = b.Put("plain", "data/c", []byte("data/c"), time.Now())
+	require.NoError(t, err)
+	err = execute("lock", "legal", "add", "s3://plain/data/", "--keep-going", "--failures-format", "json")
+	require.EqualError(t, err, "1 object(s) failed")
+	err = execute("lock", "legal", "add", "s3://plain/data/")
+	require.Error(t, err, "a failure without --keep-going is returned instead of exiting")
+	require.Contains(t, err.Error(), "can't process s3://plain/data/c")
+
+	for _, args := range [][]string{{"size", "s3://missing/data/"}, {"size", "s3://missing/data/", "-g"}} {
+		err = execute(args...)
+		require.Error(t, err, "a listing failure of size is returned instead of exiting")
+		require.Contains(t, err.Error(), "can't list objects at s3://missing/data/")
+	}
+	sizeOpts.group = false
+}
+
+func TestMultipleURLs(t *testing.T) {
+	b := newBackend(t)
+	_, err := b.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("other")})
+	require.NoError(t, err)
+	_, err = b.Put("other", "data/c", []byte("data/c"), time.Now())
+	require.NoError(t, err)
+	require.NoError(t, execute("tag", "add", "s3://bucket/data/a", "s3://bucket/data/b", "s3://other/data/", "--tags", "a=1"))
+	for _, k := range []string{"data/a", "data/b"} {
+		require.Equal(t, map[string]string{"a": "1"}, tagsOf(t, b, k, versionIds(t, b, k)[0]), k)
+	}
+	res, err := b.GetObjectTagging(&s3.GetObjectTaggingInput{Bucket: aws.String("other"), Key: aws.String("data/c")})
+	require.NoError(t, err)
+	require.Len(t, res.TagSet, 1)
+
+	err = execute("lock", "legal", "add", "s3://missing/data/", "s3://bucket/data/b", "--keep-going")
+	require.Error(t, err)
+	hold, err := b.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{Bucket: aws.String("bucket"), Key: aws.String("data/b")})
+	require.NoError(t, err, "a URL that can't be listed doesn't stop the others")
+	require.Equal(t, s3.ObjectLockLegalHoldStatusOn, *hold.LegalHold.Status)
+}
+
+func TestBucketGlobs(t *testing.T) {
+	b := newBackend(t)
+	for _, name := range []string{"logs-a", "logs-b", "logs-eu"} {
+		in := &s3.CreateBucketInput{Bucket: aws.String(name)}
+		if name == "logs-eu" {
+			in.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String("eu-west-1")}
+		}
+		_, err := b.CreateBucket(in)
+		require.NoError(t, err)
+		_, err = b.Put(name, "2020/x", []byte(name), time.Now())
+		require.NoError(t, err)
+	}
+	sessionOpts.region = "us-east-1"
+	defer func() { sessionOpts.region = "" }()
+
+	bucket, key, err := fromS3("s3://logs-[ab]/2020/a?b")
+	require.NoError(t, err)
+	require.Equal(t, "logs-[ab]", bucket)
+	require.Equal(t, "2020/a?b", key)
+	urls, err := expandURLs(context.Background(), []string{"s3://logs-*/2020/", "s3://bucket/data/", "s3://*"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"s3://logs-a/2020/", "s3://logs-b/2020/", "s3://bucket/data/",
+		"s3://bucket", "s3://logs-a", "s3://logs-b"}, urls, "logs-eu is in another region")
+	_, err = expandURLs(context.Background(), []string{"s3://missing-*/"})
+	require.Error(t, err)
+
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("size", "s3://logs-?/", "-o", "csv"))
+	})
+	require.Contains(t, out, "Bucket,Path,Count,Size\n")
+	require.Contains(t, out, "logs-a,s3://logs-a/,1,")
+	require.Contains(t, out, "logs-b,s3://logs-b/,1,")
+
+	require.NoError(t, execute("tag", "add", "s3://logs-*/2020/", "--tags", "a=1"))
+	for _, name := range []string{"logs-a", "logs-b"} {
+		res, err := b.GetObjectTagging(&s3.GetObjectTaggingInput{Bucket: aws.String(name), Key: aws.String("2020/x")})
+		require.NoError(t, err)
+		require.Len(t, res.TagSet, 1, name)
+	}
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("ls", "versions", "s3://logs-*/", "--template", "{{.Bucket}} {{.Path}}"))
+	})
+	require.Equal(t, "logs-a s3://logs-a/2020/x\nlogs-b s3://logs-b/2020/x\n", out)
+}
+
+func TestDryRun(t *testing.T) {
+	b := newBackend(t)
+	ids := versionIds(t, b, "data/b")
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("tag", "add", "s3://bucket/data/b", "--tags", "a=1", "--dry-run"))
+		require.NoError(t, execute("lock", "legal", "add", "s3://bucket/data/b", "--dry-run"))
+	})
+	require.Equal(t, "s3://bucket/data/b@"+ids[0]+" tags: none -> a=1\n"+
+		"s3://bucket/data/b@"+ids[0]+" legal hold: OFF -> ON\n", out)
+	require.Empty(t, tagsOf(t, b, "data/b", ids[0]))
+	_, err := b.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("data/b"),
+	})
+	require.Error(t, err)
+}
+
+func TestFromFile(t *testing.T) {
+	b := newBackend(t)
+	ids := versionIds(t, b, "data/a")
+	f, err := ioutil.TempFile("", "manifest")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("bucket,key,version_id\nbucket,data/a," + ids[1] + "\ns3://bucket/data/b\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, execute("tag", "add", "--from-file", f.Name(), "--tags", "a=1"))
+	require.Empty(t, tagsOf(t, b, "data/a", ids[0]))
+	require.Equal(t, map[string]string{"a": "1"}, tagsOf(t, b, "data/a", ids[1]))
+	require.Equal(t, map[string]string{"a": "1"}, tagsOf(t, b, "data/b", versionIds(t, b, "data/b")[0]))
+
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("cat", "--from-file", f.Name()))
+	})
+	require.Equal(t, "data/adata/b", out)
+	require.Error(t, execute("cat", "s3://bucket/data/", "--from-file", f.Name()))
+	require.Error(t, execute("cat", "s3://bucket/data/", "--inventory"))
+
+	defer func() { filterConf.olderThan = flagAge{} }()
+	err = execute("lock", "legal", "add", "--from-file", f.Name(), "--older-than", "30d")
+	require.Error(t, err, "the manifest has no modification times")
+	require.Contains(t, err.Error(), "--from-file can't be used with --older-than")
+	filterConf.olderThan = flagAge{}
+}
+
+func TestFromFileLatest(t *testing.T) {
+	b := newBackend(t)
+	ids := versionIds(t, b, "data/a")
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("ls", "versions", "s3://bucket/data/a", "--json"))
+	})
+	f, err := ioutil.TempFile("", "manifest")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(out)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, execute("tag", "add", "--from-file", f.Name(), "--tags", "a=1"))
+	require.Equal(t, map[string]string{"a": "1"}, tagsOf(t, b, "data/a", ids[0]))
+	require.Empty(t, tagsOf(t, b, "data/a", ids[1]), "the noncurrent version is skipped without --all")
+	require.NoError(t, execute("tag", "add", "--from-file", f.Name(), "--tags", "a=1", "--all"))
+	require.Equal(t, map[string]string{"a": "1"}, tagsOf(t, b, "data/a", ids[1]))
+}
+
+func TestCatReadAhead(t *testing.T) {
+	b := newBackend(t)
+	var expected []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("cat/%02d", i)
+		data := key + strings.Repeat(".", i*catChunkSize/10) + "\n"
+		_, err := b.Put("bucket", key, []byte(data), time.Now())
+		require.NoError(t, err)
+		expected = append(expected, data)
+	}
+	size := catConf.bufferSize
+	catConf.bufferSize.size = catChunkSize
+	defer func() { catConf.bufferSize = size }()
+
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/cat/", "--read-ahead", "4"))
+	})
+	require.Equal(t, strings.Join(expected, ""), out, "the objects are printed in the key order")
+
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/cat/", "--unordered"))
+	})
+	lines := strings.SplitAfter(out, "\n")
+	sort.Strings(lines)
+	require.Equal(t, expected, lines[1:], "every object is printed whole")
+
+	require.Error(t, execute("cat", "s3://bucket/cat/", "s3://missing/"))
+}
+
+func TestCatLines(t *testing.T) {
+	b := newBackend(t)
+	var lines []string
+	for i := 0; i < 100000; i++ {
+		lines = append(lines, fmt.Sprintf("line %05d\n", i))
+	}
+	data := strings.Join(lines, "")
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	for key, content := range map[string][]byte{"lines/a": []byte(data), "lines/b.gz": gzipped.Bytes(), "lines/c": []byte("no newline")} {
+		_, err := b.Put("bucket", key, content, time.Now())
+		require.NoError(t, err)
+	}
+
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/lines/a", "--head-lines", "3"))
+	})
+	require.Equal(t, strings.Join(lines[:3], ""), out)
+	require.True(t, atomic.LoadUint64(&progress.bytes) < uint64(len(data)/2), "the object is read with ranged GETs")
+
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/lines/a", "--tail-lines", "10000"))
+	})
+	require.Equal(t, strings.Join(lines[90000:], ""), out)
+	require.True(t, atomic.LoadUint64(&progress.bytes) < uint64(len(data)/2), "the object is read backwards")
+
+	size := catConf.bufferSize
+	defer func() { catConf.bufferSize = size }()
+	for _, bufferSize := range []string{"8MB", "300KB"} {
+		out = captureStdout(t, func() {
+			require.NoError(t, execute("cat", "s3://bucket/lines/a", "--tail-lines", "50000", "--read-ahead-size", bufferSize))
+		})
+		// the lines past --read-ahead-size are read from the start
+		require.Equal(t, strings.Join(lines[50000:], ""), out, bufferSize)
+	}
+	catConf.bufferSize = size
+
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/lines/b.gz", "--tail-lines", "2"))
+		require.NoError(t, execute("cat", "s3://bucket/lines/c", "--tail-lines", "2"))
+		require.NoError(t, execute("cat", "s3://bucket/lines/a", "--range", "11-21"))
+		require.NoError(t, execute("cat", "s3://bucket/lines/c", "--range", "100-"))
+	})
+	require.Equal(t, strings.Join(lines[99998:], "")+"no newline"+lines[1], out)
+
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("head", "s3://bucket/lines/", "-n", "2"))
+	})
+	require.Equal(t, "s3://bucket/lines/a:line 00000\ns3://bucket/lines/a:line 00001\n"+
+		"s3://bucket/lines/b.gz:line 00000\ns3://bucket/lines/b.gz:line 00001\n"+
+		"s3://bucket/lines/c:no newline\n", out)
+
+	require.Error(t, execute("cat", "s3://bucket/lines/a", "--range", "1-2-3"))
+	require.Error(t, execute("cat", "s3://bucket/lines/a", "--head-lines", "1", "--tail-lines", "1"))
+}
+
+func TestCatAsOf(t *testing.T) {
+	b := newBackend(t)
+	day := time.Date(2020, 4, 10, 0, 0, 0, 0, time.UTC)
+	put := func(key, data string, at time.Time) string {
+		id, err := b.Put("bucket", key, []byte(data), at)
+		require.NoError(t, err)
+		return id
+	}
+	first := put("v/a", "a1\n", day)
+	put("v/a", "a2, longer\n", day.Add(2*time.Hour))
+	put("v/b", "b1\n", day)
+	b.Now = func() time.Time { return day.Add(time.Hour) }
+	_, err := b.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("v/b")})
+	require.NoError(t, err)
+	put("v/c", "c1\n", day.Add(3*time.Hour))
+
+	for asOf, expected := range map[string]string{
+		"2020-04-09":           "",
+		"2020-04-10T00:30:00Z": "a1\nb1\n",
+		"2020-04-10T01:30:00Z": "a1\n",
+		"2020-04-10T12:00:00Z": "a2, longer\nc1\n",
+	} {
+		out := captureStdout(t, func() {
+			require.NoError(t, execute("cat", "s3://bucket/v/", "--as-of", asOf))
+		})
+		require.Equal(t, expected, out, asOf)
+	}
+
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/v/a", "--version-id", first))
+	})
+	require.Equal(t, "a1\n", out)
+
+	defer func() { filterConf.maxSize = flagSize{} }()
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/v/", "--as-of", "2020-04-10T12:00:00Z", "--max-size", "5"))
+	})
+	require.Equal(t, "c1\n", out, "the predicates apply to the version current at --as-of")
+	filterConf.maxSize = flagSize{}
+
+	require.Error(t, execute("cat", "s3://bucket/v/", "--version-id", first))
+	require.Error(t, execute("cat", "s3://bucket/v/a", "--version-id", first, "--as-of", "2020-04-10"))
+	require.Error(t, execute("cat", "s3://bucket/v/", "--as-of", "yesterday"))
+}
+
+func TestLsOutput(t *testing.T) {
+	b := newBackend(t)
+	ids := versionIds(t, b, "data/b")
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("ls", "versions", "s3://bucket/data/b", "--template", "{{.Path}} {{.Latest}}"))
+	})
+	require.Equal(t, "s3://bucket/data/b "+ids[0]+"\n", out)
+}
+
+func TestLsSort(t *testing.T) {
+	b := newBackend(t)
+	_, err := b.Put("bucket", "data/c", []byte("data/c, the largest"), time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	ls := func(args ...string) string {
+		return captureStdout(t, func() {
+			require.NoError(t, execute(append([]string{"ls", "versions", "s3://bucket/data/", "--template", "{{.Path}}"}, args...)...))
+		})
+	}
+	require.Equal(t, "s3://bucket/data/a\ns3://bucket/data/b\ns3://bucket/data/c\n", ls())
+	require.Equal(t, "s3://bucket/data/c\ns3://bucket/data/b\ns3://bucket/data/a\n", ls("--reverse"))
+	require.Equal(t, "s3://bucket/data/c\ns3://bucket/data/a\ns3://bucket/data/b\n", ls("--sort", "modified"))
+	require.Equal(t, "s3://bucket/data/a\ns3://bucket/data/b\ns3://bucket/data/c\n", ls("--sort", "size"))
+	require.Error(t, execute("ls", "versions", "s3://bucket/data/", "--sort", "name"))
+
+	ids := versionIds(t, b, "data/a")
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("ls", "versions", "s3://bucket/data/a", "-o", "csv"))
+	})
+	require.Contains(t, out, "s3://bucket/data/a,"+ids[0])
+	require.True(t, strings.Index(out, ids[0]) < strings.Index(out, ids[1]), "newest version goes first")
+}
+
+func TestLsTagsStream(t *testing.T) {
+	b := newBackend(t)
+	require.NoError(t, execute("tag", "add", "s3://bucket/data/", "--tags", "a=1", "--all"))
+	ids := versionIds(t, b, "data/a")
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("ls", "tags", "s3://bucket/data/a", "--all", "-o", "ndjson"))
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2, "a record per version")
+	require.Contains(t, out, ids[1])
+
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("ls", "tags", "s3://bucket/data/", "--all", "--group", "--reverse", "--template", "{{.Path}} {{len .Versions}}"))
+	})
+	require.Equal(t, "s3://bucket/data/b 1\ns3://bucket/data/a 2\n", out, "a record per path with --group")
+	require.Error(t, execute("ls", "tags", "s3://bucket/data/", "--sort", "modified"))
+	require.Error(t, execute("ls", "locks", "s3://bucket/data/", "--reverse"))
+}
+
+func TestLogsListingError(t *testing.T) {
+	newBackend(t)
+	done := make(chan error, 1)
+	go func() { done <- execute("logs", "s3://missing/logs/") }()
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("logs didn't return after the listing failed")
+	}
+}
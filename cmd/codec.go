@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	codecAuto = "auto"
+	codecNone = "none"
+)
+
+// codec is a compression format cat decodes.
+type codec struct {
+	name string
+	// leading bytes of the compressed stream, any of them
+	magic [][]byte
+	// tells if the leading bytes are of the format, for the magic bytes too short to be told from text
+	match func(head []byte) bool
+	// key suffixes, used only for the formats without magic bytes
+	suffixes []string
+	reader   func(io.Reader) (io.ReadCloser, error)
+}
+
+func nopCloser(r io.Reader, err error) (io.ReadCloser, error) {
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(r), nil
+}
+
+var codecs = []codec{
+	{
+		name:  "gzip",
+		magic: [][]byte{{0x1f, 0x8b}},
+		// concatenated members are read as one stream
+		reader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	{
+		name:   "bzip2",
+		match:  isBzip2,
+		reader: func(r io.Reader) (io.ReadCloser, error) { return nopCloser(bzip2.NewReader(r), nil) },
+	},
+	{
+		name:   "xz",
+		magic:  [][]byte{{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+		reader: func(r io.Reader) (io.ReadCloser, error) { return nopCloser(xz.NewReader(r)) },
+	},
+	{
+		name:  "zstd",
+		magic: [][]byte{{0x28, 0xb5, 0x2f, 0xfd}},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+	{
+		name:   "lz4",
+		magic:  [][]byte{{0x04, 0x22, 0x4d, 0x18}},
+		reader: func(r io.Reader) (io.ReadCloser, error) { return nopCloser(lz4.NewReader(r), nil) },
+	},
+	{
+		name:   "snappy",
+		magic:  [][]byte{append([]byte{0xff, 0x06, 0x00, 0x00}, "sNaPpY"...)},
+		reader: func(r io.Reader) (io.ReadCloser, error) { return nopCloser(snappy.NewReader(r), nil) },
+	},
+	{
+		name: "zlib",
+		// the headers of the default, best and no compression levels, the others may be plain text
+		magic:    [][]byte{{0x78, 0x9c}, {0x78, 0xda}, {0x78, 0x01}},
+		suffixes: []string{".zz", ".zlib"},
+		reader:   zlib.NewReader,
+	},
+	{
+		name:     "deflate",
+		suffixes: []string{".deflate"},
+		reader:   func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+	},
+}
+
+var (
+	bzip2Block = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2End   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// isBzip2 matches "BZh", the block size 1-9 and the magic of the first block or of the end of an empty stream.
+func isBzip2(head []byte) bool {
+	if len(head) < 10 || !bytes.HasPrefix(head, []byte("BZh")) || head[3] < '1' || head[3] > '9' {
+		return false
+	}
+	return bytes.Equal(head[4:10], bzip2Block) || bytes.Equal(head[4:10], bzip2End)
+}
+
+func codecNames() []string {
+	names := []string{codecAuto, codecNone}
+	for _, c := range codecs {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// prepareCodec validates --codec.
+func prepareCodec() error {
+	switch catConf.codec {
+	case codecAuto, codecNone:
+		return nil
+	}
+	if findCodec(catConf.codec) == nil {
+		return fmt.Errorf("unknown codec '%s', expected one of %s", catConf.codec, strings.Join(codecNames(), ", "))
+	}
+	return nil
+}
+
+func findCodec(name string) *codec {
+	for i := range codecs {
+		if codecs[i].name == name {
+			return &codecs[i]
+		}
+	}
+	return nil
+}
+
+// detectCodec returns the codec of the content starting with head, or of the key suffix if the format has no magic bytes.
+func detectCodec(key string, head []byte) *codec {
+	for i, c := range codecs {
+		if c.match != nil && c.match(head) {
+			return &codecs[i]
+		}
+		for _, m := range c.magic {
+			if bytes.HasPrefix(head, m) {
+				return &codecs[i]
+			}
+		}
+	}
+	for i, c := range codecs {
+		for _, s := range c.suffixes {
+			if strings.HasSuffix(key, s) {
+				return &codecs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// magicSize is enough of the leading bytes of the content to detect its codec.
+const magicSize = 10
+
+// selectCodec returns the --codec, or the one detected from the key and the leading bytes of the content, nil for none.
+func selectCodec(key string, head []byte) *codec {
+	switch catConf.codec {
+	case codecNone:
+		return nil
+	case codecAuto:
+		return detectCodec(key, head)
+	default:
+		return findCodec(catConf.codec)
+	}
+}
+
+// decompress returns the decoded content of the object with the --codec, detected from the leading bytes
+// of the content by default. The content is printed as it is if no codec matches.
+func decompress(key string, body io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(body)
+	var head []byte
+	if catConf.codec == codecAuto {
+		// the error is returned by the reads of the content
+		head, _ = br.Peek(magicSize)
+	}
+	c := selectCodec(key, head)
+	if c == nil {
+		return ioutil.NopCloser(br), nil
+	}
+	r, err := c.reader(br)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s as %s: %v", key, c.name, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+// bzip2Hello is "hello bzip2\n" compressed with bzip2, the standard library has no bzip2 writer.
+var bzip2Hello = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xab, 0x6b, 0xa1, 0xf1, 0x00, 0x00, 0x02, 0xd9, 0x80,
+	0x00, 0x10, 0x40, 0x00, 0x10, 0x00, 0x12, 0x64, 0xc0, 0x10, 0x20, 0x00, 0x31, 0x00, 0xd3, 0x4d, 0x04, 0x00, 0x1e,
+	0xa3, 0xef, 0x4e, 0x51, 0xa2, 0x07, 0x8b, 0xb9, 0x22, 0x9c, 0x28, 0x48, 0x55, 0xb5, 0xd0, 0xf8, 0x80,
+}
+
+func compress(t *testing.T, newWriter func(io.Writer) (io.WriteCloser, error), data string) []byte {
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	require.NoError(t, err)
+	_, err = io.WriteString(w, data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	defer func() { catConf.codec = codecAuto }()
+	const data = "hello codec\n"
+	gzipped := compress(t, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }, data)
+	for _, tc := range []struct {
+		name, key string
+		content   []byte
+		expected  string
+	}{
+		{"none", "a.csv", []byte(data), data},
+		{"gzip without suffix", "part-00000", gzipped, data},
+		{"multi-member gzip", "a.gz", append(append([]byte{}, gzipped...), gzipped...), data + data},
+		{"bzip2", "a", bzip2Hello, "hello bzip2\n"},
+		{"empty bzip2", "a", []byte("BZh9\x17rE8P\x90\x00\x00\x00\x00"), ""},
+		{"not bzip2 despite BZh", "a", []byte("BZh9 is not a block\n"), "BZh9 is not a block\n"},
+		{"xz", "a", compress(t, func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }, data), data},
+		{"zstd", "a", compress(t, func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }, data), data},
+		{"lz4", "a", compress(t, func(w io.Writer) (io.WriteCloser, error) { return lz4.NewWriter(w), nil }, data), data},
+		{"snappy", "a", compress(t, func(w io.Writer) (io.WriteCloser, error) { return snappy.NewBufferedWriter(w), nil }, data), data},
+		{"zlib", "a", compress(t, func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil }, data), data},
+		{"deflate by suffix", "a.deflate", compress(t, func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.BestSpeed) }, data), data},
+		{"not gzip despite the suffix", "a.gz", []byte(data), data},
+	} {
+		r, err := decompress(tc.key, bytes.NewReader(tc.content))
+		require.NoError(t, err, tc.name)
+		out, err := ioutil.ReadAll(r)
+		require.NoError(t, err, tc.name)
+		require.NoError(t, r.Close(), tc.name)
+		require.Equal(t, tc.expected, string(out), tc.name)
+	}
+
+	corrupted := append([]byte{}, bzip2Hello...)
+	corrupted[20] ^= 0xff
+	r, err := decompress("a.bz2", bytes.NewReader(corrupted))
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	require.Error(t, err, "a corrupted stream is an error")
+
+	catConf.codec = "none"
+	r, err = decompress("a.gz", bytes.NewReader(gzipped))
+	require.NoError(t, err)
+	out, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, gzipped, out, "--codec none")
+
+	catConf.codec = "gzip"
+	_, err = decompress("a", bytes.NewReader([]byte(data)))
+	require.Error(t, err, "--codec gzip of plain text")
+
+	catConf.codec = "rar"
+	require.Error(t, prepareCodec())
+}
@@ -7,18 +7,20 @@ import (
 	"os"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/spf13/cobra"
 )
 
 var complCmd = &cobra.Command{
 	Use:          "compliance s3://bucket/key1 s3://bucket/prefix/ ...",
 	Short:        "Add compliance lock",
-	Args:         cobra.MinimumNArgs(1),
+	Args:         urlArgs,
 	SilenceUsage: true,
-	RunE: func(_ *cobra.Command, urls []string) error {
+	RunE: func(cmd *cobra.Command, urls []string) error {
 		reader := bufio.NewReader(os.Stdin)
-		return run(urls, accessFuncBuilder(complOp(getS3(), reader)))
+		return run(cmd.Context(), urls, accessFuncBuilder(complOp(getS3(), reader)))
 	},
 }
 
@@ -34,14 +36,17 @@ var (
 	complianceMode = s3.ObjectLockRetentionModeCompliance
 )
 
-func complOp(svc *s3.S3, rdr *bufio.Reader) accessFuncT {
+func complOp(svc s3iface.S3API, rdr *bufio.Reader) accessFuncT {
 	return func(bucket string, o *s3.ObjectVersion) error {
 		expireAt := time.Now().UTC().Add(complianceConf.duration)
-		fmt.Printf("Locking s3://%s/%s version %s expires %s, proceed? (y/N):", bucket, *o.Key, *o.VersionId, expireAt.Format("2006-01-02 15:04:05"))
+		if globalOpts.dryRun {
+			return planRetention(svc, bucket, o, complianceMode, expireAt)
+		}
+		fmt.Printf("Locking s3://%s/%s version %s expires %s, proceed? (y/N):", bucket, *o.Key, aws.StringValue(o.VersionId), expireAt.Format("2006-01-02 15:04:05"))
 		answer, _, err := rdr.ReadLine()
 		switch {
 		case err == io.EOF:
-			return nil
+			return errDeclined
 		case err != nil:
 			return err
 		case string(answer) == "Y" || string(answer) == "y":
@@ -58,9 +63,8 @@ func complOp(svc *s3.S3, rdr *bufio.Reader) accessFuncT {
 			)
 			return err
 		default:
-			return nil
+			return errDeclined
 		}
-		return nil
 	}
 }
 
@@ -0,0 +1,558 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/schema"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+var (
+	parquetMagic = []byte("PAR1")
+	avroMagic    = []byte("Obj\x01")
+)
+
+// decodeFormats are the output formats of cat --decode.
+var decodeFormats = []string{outputNdjson, outputCsv, outputTsv}
+
+// prepareDecode validates the flags of cat --decode.
+func prepareDecode() error {
+	if !catConf.decode {
+		if catConf.limit > 0 || len(catConf.columns) > 0 {
+			return fmt.Errorf("--limit and --columns require --decode")
+		}
+		return nil
+	}
+	if catConf.byteRange != "" || catConf.headLines > 0 || catConf.tailLines > 0 || catConf.withKey {
+		return fmt.Errorf("--decode can't be used with --range, --head-lines, --tail-lines or --with-key")
+	}
+	switch format := outputFormat(outputNdjson); format {
+	case outputNdjson, outputCsv, outputTsv:
+	default:
+		return fmt.Errorf("--decode prints records as %s, not %s", strings.Join(decodeFormats, ", "), format)
+	}
+	return nil
+}
+
+// field is a named value of a decoded record.
+type field struct {
+	name  string
+	value interface{}
+}
+
+// fields is a record or a nested object, JSON encoded with the fields in their order.
+type fields []field
+
+func (f fields) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, v := range f {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(v.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (f fields) rows() [][]string {
+	cells := make([]string, len(f))
+	for i, v := range f {
+		cells[i] = cell(v.value)
+	}
+	return [][]string{cells}
+}
+
+// cell formats a value for the tabular output, the nested values as JSON.
+func cell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.RawMessage:
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			return s
+		}
+		if string(v) == "null" {
+			return ""
+		}
+		return string(v)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// recordReader reads the records of a decoded object.
+type recordReader interface {
+	// columns are the top level fields of the records, nil when only the records tell them
+	columns() []string
+	// next returns io.EOF after the last record
+	next() (fields, error)
+}
+
+// project returns the values of the columns of the record in their order, nil for the missing ones.
+func project(rec fields, columns []string) fields {
+	projected := make(fields, len(columns))
+	for i, c := range columns {
+		projected[i].name = c
+		for _, f := range rec {
+			if f.name == c {
+				projected[i].value = f.value
+				break
+			}
+		}
+	}
+	return projected
+}
+
+// sendRecords sends up to --limit records of r to the records of the job, setting its columns first.
+func (q *catQueue) sendRecords(job *catJob, r recordReader) error {
+	if job.columns = catConf.columns; len(job.columns) == 0 {
+		job.columns = r.columns()
+	}
+	for n := 0; catConf.limit <= 0 || n < catConf.limit; n++ {
+		rec, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if job.columns == nil {
+			// the CSV columns of JSON Lines are the fields of the first record
+			job.columns = make([]string, len(rec))
+			for i, f := range rec {
+				job.columns[i] = f.name
+			}
+		}
+		q.markReady(job)
+		select {
+		case job.records <- rec:
+		case <-q.ctx.Done():
+			return q.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// writeRecords prints the records of the job with the renderer of the run, created with the columns
+// of the first object that has them. The records are projected to these columns for the CSV output
+// and with --columns.
+func (q *catQueue) writeRecords(job *catJob) error {
+	for {
+		select {
+		case rec, ok := <-job.records:
+			// the columns are set before the first record is sent and before the records are closed
+			if q.decoded == nil && job.columns != nil {
+				q.columns = job.columns
+				q.decoded = newRenderer(os.Stdout, outputNdjson, q.columns)
+			}
+			if !ok {
+				atomic.AddUint64(&progress.processed, 1)
+				return job.err
+			}
+			if len(catConf.columns) > 0 || outputFormat(outputNdjson) != outputNdjson {
+				rec = project(rec, q.columns)
+			}
+			if err := q.decoded.add(rec); err != nil {
+				return err
+			}
+		case <-q.ctx.Done():
+			return q.ctx.Err()
+		}
+	}
+}
+
+// jsonLines reads JSON Lines, keeping the fields of every record in their order.
+type jsonLines struct {
+	r *bufio.Reader
+}
+
+func (j *jsonLines) columns() []string {
+	return nil
+}
+
+func (j *jsonLines) next() (fields, error) {
+	for {
+		line, err := j.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			return parseJSONObject(line)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseJSONObject parses a JSON object with the values of its fields as they are.
+func parseJSONObject(data []byte) (fields, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object: %.80s", bytes.TrimSpace(data))
+	}
+	var rec fields
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		rec = append(rec, field{name: t.(string), value: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// avroRecords reads an Avro object container file.
+type avroRecords struct {
+	ocf    *goavro.OCFReader
+	fields []string
+	// the fields of union types, their values are unwrapped
+	unions map[string]bool
+}
+
+func newAvroRecords(r io.Reader) (*avroRecords, error) {
+	ocf, err := goavro.NewOCFReader(r)
+	if err != nil {
+		return nil, err
+	}
+	a := &avroRecords{ocf: ocf, unions: make(map[string]bool)}
+	var s struct {
+		Type   interface{} `json:"type"`
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(ocf.Codec().Schema()), &s); err != nil || s.Type != "record" {
+		// a schema of another type than record, such as "string"
+		a.fields = []string{"value"}
+		return a, nil
+	}
+	for _, f := range s.Fields {
+		a.fields = append(a.fields, f.Name)
+		a.unions[f.Name] = bytes.HasPrefix(bytes.TrimSpace(f.Type), []byte("["))
+	}
+	return a, nil
+}
+
+func (a *avroRecords) columns() []string {
+	return a.fields
+}
+
+func (a *avroRecords) next() (fields, error) {
+	if !a.ocf.Scan() {
+		if err := a.ocf.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	datum, err := a.ocf.Read()
+	if err != nil {
+		return nil, err
+	}
+	m, ok := datum.(map[string]interface{})
+	if !ok || len(a.unions) == 0 {
+		return fields{{name: "value", value: datum}}, nil
+	}
+	rec := make(fields, len(a.fields))
+	for i, name := range a.fields {
+		value := m[name]
+		if union, ok := value.(map[string]interface{}); ok && a.unions[name] && len(union) == 1 {
+			// a union value is encoded as {"type": value}
+			for _, v := range union {
+				value = v
+			}
+		}
+		rec[i] = field{name: name, value: value}
+	}
+	return rec, nil
+}
+
+// parquetBatch is the number of rows read from a parquet file at a time.
+const parquetBatch = 1024
+
+// parquetRecords reads a parquet file, its rows are structs built from the schema.
+type parquetRecords struct {
+	pr      *reader.ParquetReader
+	handler *schema.SchemaHandler
+	rows    []interface{}
+	left    int64
+}
+
+func newParquetRecords(pf source.ParquetFile) (p *parquetRecords, err error) {
+	// parquet-go panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("can't read parquet: %v", r)
+		}
+	}()
+	pr, err := reader.NewParquetReader(pf, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetRecords{pr: pr, handler: pr.SchemaHandler, left: pr.GetNumRows()}, nil
+}
+
+func (p *parquetRecords) columns() []string {
+	var columns []string
+	for _, path := range p.handler.ValueColumns {
+		// the top level fields, the leaves of the nested ones share them
+		in := strings.Join(strings.Split(path, ".")[:2], ".")
+		name := p.exName(in)
+		if len(columns) == 0 || columns[len(columns)-1] != name {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// exName returns the name of the field in the file for the path of its struct field.
+func (p *parquetRecords) exName(inPath string) string {
+	ex, ok := p.handler.InPathToExPath[inPath]
+	if !ok {
+		ex = inPath
+	}
+	return ex[strings.LastIndex(ex, ".")+1:]
+}
+
+func (p *parquetRecords) next() (rec fields, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("can't read parquet: %v", r)
+		}
+	}()
+	if len(p.rows) == 0 {
+		if p.left <= 0 {
+			p.pr.ReadStop()
+			return nil, io.EOF
+		}
+		n := int64(parquetBatch)
+		if n > p.left {
+			n = p.left
+		}
+		if p.rows, err = p.pr.ReadByNumber(int(n)); err != nil {
+			return nil, err
+		}
+		if len(p.rows) == 0 {
+			return nil, errors.New("can't read parquet: no rows left")
+		}
+		p.left -= int64(len(p.rows))
+	}
+	row := p.rows[0]
+	p.rows = p.rows[1:]
+	rec, _ = p.value(reflect.ValueOf(row), p.handler.GetRootInName()).(fields)
+	return rec, nil
+}
+
+// value converts a value of a row to the fields of its structs, named as in the file.
+func (p *parquetRecords) value(v reflect.Value, inPath string) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return p.value(v.Elem(), inPath)
+	case reflect.Struct:
+		rec := make(fields, v.NumField())
+		for i := range rec {
+			path := inPath + "." + v.Type().Field(i).Name
+			rec[i] = field{name: p.exName(path), value: p.value(v.Field(i), path)}
+		}
+		return rec
+	case reflect.Slice:
+		// the elements of a LIST are under List.Element, the repeated fields have none
+		elemPath := inPath
+		if _, ok := p.handler.InPathToExPath[inPath+".List.Element"]; ok {
+			elemPath = inPath + ".List.Element"
+		}
+		values := make([]interface{}, v.Len())
+		for i := range values {
+			values[i] = p.value(v.Index(i), elemPath)
+		}
+		return values
+	case reflect.Map:
+		values := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			values[fmt.Sprint(iter.Key().Interface())] = p.value(iter.Value(), inPath+".Key_value.Value")
+		}
+		return values
+	default:
+		return v.Interface()
+	}
+}
+
+// parquetObject is a parquet file read from S3 with ranged GETs, the reader seeks to the footer
+// and to the column chunks it needs. The reads are served from a window of the object fetched
+// with a GET of at least catChunkSize, growing while the reads go on sequentially.
+type parquetObject struct {
+	q      *catQueue
+	job    *catJob
+	size   int64
+	offset int64
+	// the fetched bytes of the object from windowStart
+	window      []byte
+	windowStart int64
+	// the size of the next GET
+	next int64
+}
+
+func (p *parquetObject) Read(b []byte) (int, error) {
+	if p.offset >= p.size {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	// the reader expects full reads, as many as the object has
+	var n int
+	for n < len(b) && p.offset < p.size {
+		if p.offset < p.windowStart || p.offset >= p.windowStart+int64(len(p.window)) {
+			if err := p.fetch(int64(len(b) - n)); err != nil {
+				return n, err
+			}
+		}
+		copied := copy(b[n:], p.window[p.offset-p.windowStart:])
+		n, p.offset = n+copied, p.offset+int64(copied)
+	}
+	return n, nil
+}
+
+// fetch replaces the window with at least n bytes from the offset, up to the end of the object.
+func (p *parquetObject) fetch(n int64) error {
+	switch {
+	case p.next == 0 || p.offset != p.windowStart+int64(len(p.window)):
+		// a seek, start over
+		p.next = catChunkSize
+	case p.next < maxRangeSize:
+		p.next *= 2
+	}
+	if n < p.next {
+		n = p.next
+	}
+	end := p.offset + n
+	if end > p.size {
+		end = p.size
+	}
+	data, _, err := p.q.readRange(p.job, fmt.Sprintf("%d-%d", p.offset, end-1))
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	p.window, p.windowStart = data, p.offset
+	return nil
+}
+
+func (p *parquetObject) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += p.offset
+	case io.SeekEnd:
+		offset += p.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("can't seek to %d", offset)
+	}
+	p.offset = offset
+	return offset, nil
+}
+
+func (p *parquetObject) Write([]byte) (int, error) {
+	return 0, errors.New("parquet objects are read only")
+}
+
+func (p *parquetObject) Close() error {
+	return nil
+}
+
+// Open returns another reader of the object, the reader opens one for every column.
+func (p *parquetObject) Open(string) (source.ParquetFile, error) {
+	return &parquetObject{q: p.q, job: p.job, size: p.size}, nil
+}
+
+func (p *parquetObject) Create(string) (source.ParquetFile, error) {
+	return nil, errors.New("parquet objects are read only")
+}
+
+// copyDecoded sends the records of a Parquet, Avro or JSON Lines object, compressed or not, see sendRecords.
+func (q *catQueue) copyDecoded(job *catJob) error {
+	head, size, err := q.readRange(job, fmt.Sprintf("0-%d", magicSize-1))
+	if err != nil || size == 0 {
+		return err
+	}
+	if bytes.HasPrefix(head, parquetMagic) {
+		records, err := newParquetRecords(&parquetObject{q: q, job: job, size: size})
+		if err != nil {
+			return readError(job, err)
+		}
+		return q.sendRecords(job, objectRecords{records, job})
+	}
+	body := newRangeReader(q, job)
+	defer body.Close()
+	reader, err := decompress(*job.key, errorReader{body, job})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	br := bufio.NewReaderSize(errorReader{reader, job}, catChunkSize)
+	if magic, _ := br.Peek(len(avroMagic)); bytes.Equal(magic, avroMagic) {
+		records, err := newAvroRecords(br)
+		if err != nil {
+			return readError(job, err)
+		}
+		return q.sendRecords(job, objectRecords{records, job})
+	}
+	return q.sendRecords(job, objectRecords{&jsonLines{r: br}, job})
+}
+
+// objectRecords wraps the errors of decoding the object, see readError.
+type objectRecords struct {
+	recordReader
+	job *catJob
+}
+
+func (r objectRecords) next() (fields, error) {
+	rec, err := r.recordReader.next()
+	if err != nil && err != io.EOF {
+		err = readError(r.job, err)
+	}
+	return rec, err
+}
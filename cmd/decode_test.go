@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type decodeEvent struct {
+	Id   int64    `parquet:"name=id, type=INT64"`
+	Name *string  `parquet:"name=name, type=UTF8, repetitiontype=OPTIONAL"`
+	Tags []string `parquet:"name=tags, type=LIST, valuetype=UTF8"`
+}
+
+func TestCatDecode(t *testing.T) {
+	b := newBackend(t)
+	name := "a"
+
+	var pq bytes.Buffer
+	pw, err := writer.NewParquetWriter(writerfile.NewWriterFile(&pq), new(decodeEvent), 1)
+	require.NoError(t, err)
+	require.NoError(t, pw.Write(decodeEvent{Id: 1, Name: &name, Tags: []string{"x", "y"}}))
+	require.NoError(t, pw.Write(decodeEvent{Id: 2}))
+	require.NoError(t, pw.WriteStop())
+
+	var avro bytes.Buffer
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:      &avro,
+		Schema: `{"type":"record","name":"event","fields":[{"name":"id","type":"long"},{"name":"name","type":["null","string"]}]}`,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ocf.Append([]map[string]interface{}{
+		{"id": 1, "name": goavro.Union("string", "a")},
+		{"id": 2, "name": nil},
+	}))
+
+	var jsonl bytes.Buffer
+	gz := gzip.NewWriter(&jsonl)
+	_, err = gz.Write([]byte("{\"id\":1,\"name\":\"a\",\"extra\":true}\n\n{\"name\":null,\"id\":2}\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	for key, data := range map[string][]byte{"decode/a.parquet": pq.Bytes(), "decode/b.avro": avro.Bytes(), "decode/c.jsonl.gz": jsonl.Bytes()} {
+		_, err := b.Put("bucket", key, data, time.Now())
+		require.NoError(t, err)
+	}
+
+	out := captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/decode/", "--decode"))
+	})
+	require.Equal(t, `{"id":1,"name":"a","tags":["x","y"]}
+{"id":2,"name":null,"tags":[]}
+{"id":1,"name":"a"}
+{"id":2,"name":null}
+{"id":1,"name":"a","extra":true}
+{"name":null,"id":2}
+`, out)
+
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/decode/", "--decode", "-o", "csv", "--limit", "1", "--columns", "name,id"))
+	})
+	require.Equal(t, "name,id\na,1\na,1\na,1\n", out)
+
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/decode/", "--decode", "-o", "csv"))
+	})
+	require.Equal(t, "id,name,tags\n1,a,\"[\"\"x\"\",\"\"y\"\"]\"\n2,,[]\n1,a,\n2,,\n1,a,\n2,,\n", out,
+		"the records are projected to the columns of the first object")
+
+	pq.Reset()
+	pw, err = writer.NewParquetWriter(writerfile.NewWriterFile(&pq), new(decodeEvent), 1)
+	require.NoError(t, err)
+	pw.PageSize, pw.RowGroupSize = 1024, 16*1024
+	for i := 0; i < 20000; i++ {
+		require.NoError(t, pw.Write(decodeEvent{Id: int64(i), Name: &name}))
+	}
+	require.NoError(t, pw.WriteStop())
+	_, err = b.Put("bucket", "pages.parquet", pq.Bytes(), time.Now())
+	require.NoError(t, err)
+	out = captureStdout(t, func() {
+		require.NoError(t, execute("cat", "s3://bucket/pages.parquet", "--decode"))
+	})
+	require.Equal(t, 20000, strings.Count(out, "\n"))
+	require.LessOrEqual(t, atomic.LoadUint64(&progress.requests), uint64(12),
+		"the pages are read from windows of at least catChunkSize, not with a GET per read")
+
+	require.Error(t, execute("cat", "s3://bucket/decode/", "--decode", "-o", "table"))
+	require.Error(t, execute("cat", "s3://bucket/decode/", "--limit", "1"))
+}
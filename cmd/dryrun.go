@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const noLockConfigurationCode = "NoSuchObjectLockConfiguration"
+
+var planMutex sync.Mutex
+
+// printPlan prints the change --dry-run would make to the object version instead of applying it.
+func printPlan(bucket string, o *s3.ObjectVersion, what, before, after string) {
+	planMutex.Lock()
+	defer planMutex.Unlock()
+	fmt.Fprintf(os.Stdout, "s3://%s/%s@%s %s: %s -> %s\n", bucket, *o.Key, aws.StringValue(o.VersionId), what, before, after)
+}
+
+func describeTags(ts []*s3.Tag) string {
+	if len(ts) == 0 {
+		return "none"
+	}
+	tags := make([]string, len(ts))
+	for i, t := range ts {
+		tags[i] = *t.Key + "=" + *t.Value
+	}
+	return strings.Join(tags, ",")
+}
+
+func describeRetention(r *s3.ObjectLockRetention) string {
+	if r == nil || r.Mode == nil {
+		return "none"
+	}
+	return *r.Mode + " until " + r.RetainUntilDate.Format("2006-01-02 15:04:05 -0700 MST")
+}
+
+// planRetention prints the current retention of the version and the one that would replace it.
+func planRetention(svc s3iface.S3API, bucket string, o *s3.ObjectVersion, mode string, until time.Time) error {
+	current, err := currentRetention(svc, bucket, o)
+	if err != nil {
+		return err
+	}
+	printPlan(bucket, o, "retention", describeRetention(current), describeRetention(&s3.ObjectLockRetention{
+		Mode:            &mode,
+		RetainUntilDate: &until,
+	}))
+	return nil
+}
+
+// currentLegalHold returns the legal hold status of the version, OFF if it was never set.
+func currentLegalHold(svc s3iface.S3API, bucket string, o *s3.ObjectVersion) (string, error) {
+	res, err := svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket:    &bucket,
+		Key:       o.Key,
+		VersionId: o.VersionId,
+	})
+	switch {
+	case err != nil:
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == noLockConfigurationCode {
+			return s3.ObjectLockLegalHoldStatusOff, nil
+		}
+		return "", err
+	case res.LegalHold == nil || res.LegalHold.Status == nil:
+		return s3.ObjectLockLegalHoldStatusOff, nil
+	default:
+		return *res.LegalHold.Status, nil
+	}
+}
+
+// currentRetention returns the retention of the version, nil if it was never set.
+func currentRetention(svc s3iface.S3API, bucket string, o *s3.ObjectVersion) (*s3.ObjectLockRetention, error) {
+	res, err := svc.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket:    &bucket,
+		Key:       o.Key,
+		VersionId: o.VersionId,
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == noLockConfigurationCode {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return res.Retention, nil
+}
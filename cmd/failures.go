@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	failuresTable = "table"
+	failuresJson  = "json"
+)
+
+// Failure describes an object that couldn't be processed in --keep-going mode.
+type Failure struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	VersionId string `json:"version_id,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// failures collects per-object errors from concurrent workers.
+type failures struct {
+	mu   sync.Mutex
+	list []Failure
+}
+
+func (f *failures) add(bucket, key, versionId string, err error) {
+	failure := Failure{
+		Bucket:    bucket,
+		Key:       key,
+		VersionId: versionId,
+		Message:   err.Error(),
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		failure.Code = awsErr.Code()
+		failure.Message = awsErr.Message()
+	}
+	f.mu.Lock()
+	f.list = append(f.list, failure)
+	f.mu.Unlock()
+}
+
+// report prints the collected failures to stderr and returns an error if there were any.
+func (f *failures) report() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.list) == 0 {
+		return nil
+	}
+	if err := renderFailures(os.Stderr, f.list); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d object(s) failed", len(f.list))
+}
+
+func renderFailures(w io.Writer, list []Failure) error {
+	switch globalOpts.failuresFormat {
+	case failuresJson:
+		return json.NewEncoder(w).Encode(list)
+	default:
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"Bucket", "Key", "Version", "Code", "Message"})
+		for _, f := range list {
+			table.Append([]string{f.Bucket, f.Key, f.VersionId, f.Code, f.Message})
+		}
+		table.Render()
+		return nil
+	}
+}
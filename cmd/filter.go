@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dustin/go-humanize"
+)
+
+var filterConf struct {
+	include []string
+	exclude []string
+	match   string
+	matchRe *regexp.Regexp
+
+	olderThan      flagAge
+	newerThan      flagAge
+	modifiedBefore flagTime
+	modifiedAfter  flagTime
+	minSize        flagSize
+	maxSize        flagSize
+	// bounds of LastModified derived from the flags above
+	notBefore time.Time
+	notAfter  time.Time
+}
+
+// prepareFilters validates the glob patterns, compiles the --match expression
+// and turns the age and date flags into bounds of LastModified.
+func prepareFilters() error {
+	for _, pattern := range append(filterConf.include, filterConf.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	filterConf.matchRe = nil
+	if filterConf.match != "" {
+		re, err := regexp.Compile(filterConf.match)
+		if err != nil {
+			return err
+		}
+		filterConf.matchRe = re
+	}
+	now := time.Now()
+	filterConf.notBefore, filterConf.notAfter = time.Time{}, time.Time{}
+	if filterConf.newerThan.set {
+		filterConf.notBefore = now.Add(-filterConf.newerThan.Duration)
+	}
+	if !filterConf.modifiedAfter.IsZero() && filterConf.modifiedAfter.After(filterConf.notBefore) {
+		filterConf.notBefore = filterConf.modifiedAfter.Time
+	}
+	if filterConf.olderThan.set {
+		filterConf.notAfter = now.Add(-filterConf.olderThan.Duration)
+	}
+	if !filterConf.modifiedBefore.IsZero() && (filterConf.notAfter.IsZero() || filterConf.modifiedBefore.Before(filterConf.notAfter)) {
+		filterConf.notAfter = filterConf.modifiedBefore.Time
+	}
+	return nil
+}
+
+// globMatch matches the pattern against the whole key if it contains a slash
+// and against the last path element of the key otherwise, so '*.parquet' matches at any depth.
+func globMatch(pattern, key string) bool {
+	if !strings.Contains(pattern, "/") {
+		key = path.Base(key)
+	}
+	ok, _ := path.Match(pattern, key)
+	return ok
+}
+
+func anyGlobMatch(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if globMatch(p, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKey tells if the key passes --include, --exclude and --match filters.
+func matchKey(key string) bool {
+	switch {
+	case len(filterConf.include) > 0 && !anyGlobMatch(filterConf.include, key):
+		return false
+	case anyGlobMatch(filterConf.exclude, key):
+		return false
+	case filterConf.matchRe != nil && !filterConf.matchRe.MatchString(key):
+		return false
+	default:
+		return true
+	}
+}
+
+// matchObject tells if the object passes the key filters and the age and size predicates.
+func matchObject(key string, lastModified time.Time, size int64) bool {
+	switch {
+	case !filterConf.notBefore.IsZero() && lastModified.Before(filterConf.notBefore):
+		return false
+	case !filterConf.notAfter.IsZero() && !lastModified.Before(filterConf.notAfter):
+		return false
+	case filterConf.minSize.set && uint64(size) < filterConf.minSize.size:
+		return false
+	case filterConf.maxSize.set && uint64(size) > filterConf.maxSize.size:
+		return false
+	default:
+		return matchKey(key)
+	}
+}
+
+func filterObjects(objects []*s3.Object) []*s3.Object {
+	res := objects[:0:0]
+	for _, o := range objects {
+		if matchObject(*o.Key, aws.TimeValue(o.LastModified), aws.Int64Value(o.Size)) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+func filterVersions(versions []*s3.ObjectVersion) []*s3.ObjectVersion {
+	res := versions[:0:0]
+	for _, v := range versions {
+		if matchObject(*v.Key, aws.TimeValue(v.LastModified), aws.Int64Value(v.Size)) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// flagAge is a duration that also accepts days and weeks, e.g. 90d, 2w or 1d12h.
+type flagAge struct {
+	time.Duration
+	set bool
+}
+
+var ageUnitRe = regexp.MustCompile(`^(\d+)([dw])(.*)$`)
+
+func (a *flagAge) Set(value string) error {
+	var d time.Duration
+	for m := ageUnitRe.FindStringSubmatch(value); m != nil; m = ageUnitRe.FindStringSubmatch(value) {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return err
+		}
+		days := time.Duration(n) * 24 * time.Hour
+		if m[2] == "w" {
+			days *= 7
+		}
+		d, value = d+days, m[3]
+	}
+	if value != "" {
+		rest, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		d += rest
+	}
+	a.Duration, a.set = d, true
+	return nil
+}
+
+func (a *flagAge) String() string {
+	if !a.set {
+		return ""
+	}
+	return a.Duration.String()
+}
+
+func (a *flagAge) Type() string {
+	return "Age"
+}
+
+// flagSize is a number of bytes, also accepts units, e.g. 1GB or 512KiB.
+type flagSize struct {
+	size uint64
+	set  bool
+}
+
+func (s *flagSize) Set(value string) error {
+	size, err := humanize.ParseBytes(value)
+	if err != nil {
+		return fmt.Errorf("invalid size '%s': %v", value, err)
+	}
+	s.size, s.set = size, true
+	return nil
+}
+
+func (s *flagSize) String() string {
+	if !s.set {
+		return ""
+	}
+	return humanize.Bytes(s.size)
+}
+
+func (s *flagSize) Type() string {
+	return "Size"
+}
+
+func init() {
+	pf := rootCmd.PersistentFlags()
+	pf.StringArrayVar(&filterConf.include, "include", nil, "process only keys matching the glob, e.g. '*.parquet' ( can be repeated )")
+	pf.StringArrayVar(&filterConf.exclude, "exclude", nil, "skip keys matching the glob, e.g. '_SUCCESS' ( can be repeated )")
+	pf.StringVar(&filterConf.match, "match", "", "process only keys matching the regular expression")
+	pf.Var(&filterConf.olderThan, "older-than", "process only objects modified earlier than the age ago ( 90d, 2w, 36h )")
+	pf.Var(&filterConf.newerThan, "newer-than", "process only objects modified within the age ( 90d, 2w, 36h )")
+	pf.Var(&filterConf.modifiedBefore, "modified-before", "process only objects modified before the date ( YYYY-MM-DD )")
+	pf.Var(&filterConf.modifiedAfter, "modified-after", "process only objects modified on or after the date ( YYYY-MM-DD )")
+	pf.Var(&filterConf.minSize, "min-size", "process only objects of at least the size ( 1024, 10MB, 1GiB )")
+	pf.Var(&filterConf.maxSize, "max-size", "process only objects of at most the size ( 1024, 10MB, 1GiB )")
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchKey(t *testing.T) {
+	defer func() {
+		filterConf.include, filterConf.exclude, filterConf.match = nil, nil, ""
+		require.NoError(t, prepareFilters())
+	}()
+	filterConf.include = []string{"*.parquet", "logs/*.gz"}
+	filterConf.exclude = []string{"_SUCCESS", "*.crc", "tmp-*"}
+	filterConf.match = `dt=2020-0[1-3]`
+	require.NoError(t, prepareFilters())
+
+	for key, expected := range map[string]bool{
+		"data/dt=2020-01-10/part-0.parquet":     true,
+		"data/dt=2020-04-10/part-0.parquet":     false,
+		"data/dt=2020-01-10/tmp-part-0.parquet": false,
+		"data/dt=2020-01-10/_SUCCESS":           false,
+		"data/dt=2020-01-10/part-0.csv":         false,
+		"logs/dt=2020-02-01.gz":                 true,
+		"old/logs/dt=2020-02-01.gz":             false,
+	} {
+		require.Equal(t, expected, matchKey(key), key)
+	}
+
+	filterConf.include = []string{"[a-"}
+	require.Error(t, prepareFilters())
+}
+
+func TestMatchObject(t *testing.T) {
+	defer func() {
+		filterConf.olderThan, filterConf.modifiedAfter, filterConf.minSize = flagAge{}, flagTime{}, flagSize{}
+		require.NoError(t, prepareFilters())
+	}()
+	require.NoError(t, filterConf.olderThan.Set("1w2d"))
+	require.Equal(t, 9*24*time.Hour, filterConf.olderThan.Duration)
+	require.NoError(t, filterConf.modifiedAfter.Set("2020-01-01"))
+	require.NoError(t, filterConf.minSize.Set("1KiB"))
+	require.Error(t, filterConf.maxSize.Set("lots"))
+	require.NoError(t, prepareFilters())
+
+	now := time.Now()
+	require.True(t, matchObject("key", now.AddDate(0, 0, -10), 1024))
+	require.False(t, matchObject("key", now.AddDate(0, 0, -8), 1024), "too new")
+	require.False(t, matchObject("key", time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC), 1024), "too old")
+	require.False(t, matchObject("key", now.AddDate(0, 0, -10), 1023), "too small")
+}
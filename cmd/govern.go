@@ -3,34 +3,38 @@ package cmd
 import (
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/spf13/cobra"
 )
 
 var governCmd = &cobra.Command{
 	Use:          "governance",
 	Short:        "Add/remove governance lock",
-	Args:         cobra.MinimumNArgs(1),
+	Args:         urlArgs,
 	SilenceUsage: true,
 }
 
 var governAdd = &cobra.Command{
 	Use:          "add s3://bucket/key1 s3://bucket/prefix/ ...",
 	Short:        "Add governance lock for given object(s)",
-	Args:         cobra.MinimumNArgs(1),
+	Annotations:  map[string]string{progressAnnotation: progressStderr},
+	Args:         urlArgs,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return run(args, accessFuncBuilder(governOp(getS3(), "ON")))
+		return run(cmd.Context(), args, accessFuncBuilder(governOp(getS3(), "ON")))
 	},
 }
 
 var governRm = &cobra.Command{
 	Use:          "rm s3://bucket/key1 s3://bucket/prefix/ ...",
 	Short:        "Remove governance lock for given object(s)",
-	Args:         cobra.MinimumNArgs(1),
+	Annotations:  map[string]string{progressAnnotation: progressStderr},
+	Args:         urlArgs,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return run(args, accessFuncBuilder(governOp(getS3(), "OFF")))
+		return run(cmd.Context(), args, accessFuncBuilder(governOp(getS3(), "OFF")))
 	},
 }
 
@@ -48,12 +52,15 @@ var (
 	bypass  = true
 )
 
-func governOp(svc *s3.S3, opCode string) accessFuncT {
+func governOp(svc s3iface.S3API, opCode string) accessFuncT {
 	switch opCode {
 	case "ON":
 		return func(bucket string, o *s3.ObjectVersion) error {
-			log.Infof("governance %s: s3://%s/%s@%s", opCode, bucket, *o.Key, *o.VersionId)
 			expireAt := time.Now().UTC().Add(govConf.duration)
+			if globalOpts.dryRun {
+				return planRetention(svc, bucket, o, govMode, expireAt)
+			}
+			log.Infof("governance %s: s3://%s/%s@%s", opCode, bucket, *o.Key, aws.StringValue(o.VersionId))
 			_, err := svc.PutObjectRetention(
 				&s3.PutObjectRetentionInput{
 					Bucket: &bucket,
@@ -69,8 +76,11 @@ func governOp(svc *s3.S3, opCode string) accessFuncT {
 		}
 	case "OFF":
 		return func(bucket string, o *s3.ObjectVersion) error {
-			log.Infof("governance %s: s3://%s/%s@%s", opCode, bucket, *o.Key, *o.VersionId)
 			expireAt := time.Now().UTC().Add(1 * time.Second)
+			if globalOpts.dryRun {
+				return planRetention(svc, bucket, o, govMode, expireAt)
+			}
+			log.Infof("governance %s: s3://%s/%s@%s", opCode, bucket, *o.Key, aws.StringValue(o.VersionId))
 			_, err := svc.PutObjectRetention(
 				&s3.PutObjectRetentionInput{
 					Bucket: &bucket,
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var headConf = struct {
+	lines int
+	noKey bool
+}{}
+
+var headCmd = &cobra.Command{
+	Use:          "head s3://bucket/prefix/ ...",
+	Short:        "Print the first lines of S3 file(s) prefixed with their URLs, as cat --head-lines --with-key",
+	Annotations:  map[string]string{progressAnnotation: progressStdout},
+	Args:         urlArgs,
+	SilenceUsage: true,
+	PreRunE: func(*cobra.Command, []string) error {
+		if headConf.lines <= 0 {
+			return fmt.Errorf("--lines must be positive, got %d", headConf.lines)
+		}
+		catConf.headLines, catConf.withKey = headConf.lines, !headConf.noKey
+		return prepareCat()
+	},
+	RunE: runCat,
+}
+
+func init() {
+	f := headCmd.Flags()
+	initCatFlags(f)
+	f.IntVarP(&headConf.lines, "lines", "n", 10, "lines printed of every object")
+	f.BoolVar(&headConf.noKey, "no-key", false, "print the lines without the S3 URLs of their objects")
+	rootCmd.AddCommand(headCmd)
+}
@@ -1,34 +1,38 @@
 package cmd
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/spf13/cobra"
 )
 
 var legalCmd = &cobra.Command{
 	Use:          "legal",
 	Short:        "Add/remove legal hold",
-	Args:         cobra.MinimumNArgs(1),
+	Args:         urlArgs,
 	SilenceUsage: true,
 }
 
 var legalAdd = &cobra.Command{
 	Use:          "add s3://bucket/key1 s3://bucket/prefix/ ...",
 	Short:        "Add legal hold for given object(s)",
-	Args:         cobra.MinimumNArgs(1),
+	Annotations:  map[string]string{progressAnnotation: progressStderr},
+	Args:         urlArgs,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return run(args, accessFuncBuilder(holdOp(getS3(), s3.ObjectLockLegalHoldStatusOn)))
+		return run(cmd.Context(), args, accessFuncBuilder(holdOp(getS3(), s3.ObjectLockLegalHoldStatusOn)))
 	},
 }
 
 var legalRm = &cobra.Command{
 	Use:          "rm s3://bucket/key1 s3://bucket/prefix/ ...",
 	Short:        "Remove legal hold for given object(s)",
-	Args:         cobra.MinimumNArgs(1),
+	Annotations:  map[string]string{progressAnnotation: progressStderr},
+	Args:         urlArgs,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return run(args, accessFuncBuilder(holdOp(getS3(), s3.ObjectLockLegalHoldStatusOff)))
+		return run(cmd.Context(), args, accessFuncBuilder(holdOp(getS3(), s3.ObjectLockLegalHoldStatusOff)))
 	},
 }
 
@@ -39,9 +43,17 @@ func init() {
 	legalCmd.AddCommand(legalAdd, legalRm)
 }
 
-func holdOp(svc *s3.S3, opCode string) accessFuncT {
+func holdOp(svc s3iface.S3API, opCode string) accessFuncT {
 	return func(bucket string, o *s3.ObjectVersion) error {
-		log.Infof("hold %s: s3://%s/%s@%s", opCode, bucket, *o.Key, *o.VersionId)
+		if globalOpts.dryRun {
+			current, err := currentLegalHold(svc, bucket, o)
+			if err != nil {
+				return err
+			}
+			printPlan(bucket, o, "legal hold", current, opCode)
+			return nil
+		}
+		log.Infof("hold %s: s3://%s/%s@%s", opCode, bucket, *o.Key, aws.StringValue(o.VersionId))
 		_, err := svc.PutObjectLegalHold(
 			&s3.PutObjectLegalHoldInput{
 				Bucket: &bucket,
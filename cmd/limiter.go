@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	// successful requests needed to allow one more concurrent request
+	limiterRampUp = 20
+	// how often the current rate is reported with --debug
+	limiterReportInterval = 5 * time.Second
+	limiterMinBackoff     = 100 * time.Millisecond
+	limiterMaxBackoff     = 20 * time.Second
+)
+
+// limiter adapts the number of concurrent S3 requests to throttling: the limit is halved
+// whenever S3 responds with SlowDown/503 and grows back by one after a series of successful requests.
+// Throttled requests are retried with exponential backoff while the retry budget lasts.
+type limiter struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	max       int
+	active    int
+	successes int
+	budget    int // remaining retries, negative means unlimited
+
+	completed  int
+	reportedAt time.Time
+}
+
+func newLimiter(max, budget int) *limiter {
+	if max < 1 {
+		max = 1
+	}
+	l := &limiter{
+		limit:      max,
+		max:        max,
+		budget:     budget,
+		reportedAt: time.Now(),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+var (
+	limiterOnce   sync.Once
+	sharedLimiter *limiter
+)
+
+// getLimiter returns the limiter shared by all worker pools of the command.
+func getLimiter() *limiter {
+	limiterOnce.Do(func() {
+		sharedLimiter = newLimiter(globalOpts.workers, globalOpts.retryBudget)
+	})
+	return sharedLimiter
+}
+
+func isThrottle(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusServiceUnavailable {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "SlowDown" {
+		return true
+	}
+	return request.IsErrorThrottle(err)
+}
+
+// do runs f once a request slot is available and retries it while S3 throttles.
+func (l *limiter) do(ctx context.Context, f func() error) error {
+	for attempt := 0; ; attempt++ {
+		l.acquire()
+		atomic.AddUint64(&progress.requests, 1)
+		err := f()
+		throttled := isThrottle(err)
+		l.release(throttled)
+		if !throttled || !l.retry() {
+			return err
+		}
+		apiCalls.limiterRetry()
+		backoff := limiterMinBackoff << uint(attempt)
+		if backoff > limiterMaxBackoff || backoff <= 0 {
+			backoff = limiterMaxBackoff
+		}
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+		log.Debugf("throttled, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+	l.mu.Unlock()
+}
+
+func (l *limiter) release(throttled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.completed++
+	switch {
+	case throttled:
+		l.successes = 0
+		if l.limit > 1 {
+			l.limit /= 2
+			log.Debugf("throttled by S3, concurrency lowered to %d", l.limit)
+		}
+	case l.limit < l.max:
+		l.successes++
+		if l.successes >= limiterRampUp {
+			l.successes = 0
+			l.limit++
+			log.Debugf("concurrency raised to %d", l.limit)
+		}
+	}
+	if elapsed := time.Since(l.reportedAt); elapsed >= limiterReportInterval {
+		log.Debugf("%.1f req/s, concurrency %d of %d", float64(l.completed)/elapsed.Seconds(), l.limit, l.max)
+		l.completed, l.reportedAt = 0, time.Now()
+	}
+	l.cond.Broadcast()
+}
+
+// retry takes one retry from the budget.
+func (l *limiter) retry() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.budget < 0:
+		return true
+	case l.budget == 0:
+		return false
+	default:
+		l.budget--
+		return true
+	}
+}
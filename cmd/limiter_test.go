@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	slowDown := awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), http.StatusServiceUnavailable, "")
+	l := newLimiter(8, 3)
+	calls := 0
+	require.NoError(t, l.do(context.Background(), func() error {
+		calls++
+		if calls <= 2 {
+			return slowDown
+		}
+		return nil
+	}))
+	require.Equal(t, 3, calls)
+	require.Equal(t, 2, l.limit)
+
+	for i := 0; i < limiterRampUp; i++ {
+		require.NoError(t, l.do(context.Background(), func() error { return nil }))
+	}
+	require.Equal(t, 3, l.limit)
+
+	calls = 0
+	require.Equal(t, slowDown, l.do(context.Background(), func() error {
+		calls++
+		return slowDown
+	}))
+	require.Equal(t, 2, calls, "only one retry is left in the budget")
+}
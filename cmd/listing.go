@@ -0,0 +1,293 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spf13/pflag"
+)
+
+// listObjects pages through ListObjectsV2 like ListObjectsV2PagesWithContext,
+// sending every page request through the shared limiter. The objects of the page
+// passed to fn are filtered with --include, --exclude and --match.
+func listObjects(ctx context.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	var (
+		svc = getS3()
+		lim = getLimiter()
+		in  = *input
+	)
+	for {
+		var res *s3.ListObjectsV2Output
+		if err := lim.do(ctx, func() (err error) {
+			res, err = svc.ListObjectsV2WithContext(ctx, &in)
+			return err
+		}); err != nil {
+			return err
+		}
+		last := !aws.BoolValue(res.IsTruncated)
+		page := *res
+		page.Contents = filterObjects(res.Contents)
+		atomic.AddUint64(&progress.listed, uint64(len(page.Contents)))
+		if !fn(&page, last) || last {
+			return nil
+		}
+		in.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// listObjectVersions pages through ListObjectVersions like ListObjectVersionsPagesWithContext,
+// sending every page request through the shared limiter. The versions of the page
+// passed to fn are filtered with filter, all of them are passed if it's nil.
+func listObjectVersions(ctx context.Context, input *s3.ListObjectVersionsInput, filter func([]*s3.ObjectVersion) []*s3.ObjectVersion,
+	fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+	var (
+		svc = getS3()
+		lim = getLimiter()
+		in  = *input
+	)
+	for {
+		var res *s3.ListObjectVersionsOutput
+		if err := lim.do(ctx, func() (err error) {
+			res, err = svc.ListObjectVersionsWithContext(ctx, &in)
+			return err
+		}); err != nil {
+			return err
+		}
+		last := !aws.BoolValue(res.IsTruncated)
+		page := *res
+		if filter != nil {
+			page.Versions = filter(res.Versions)
+		}
+		atomic.AddUint64(&progress.listed, uint64(len(page.Versions)))
+		if !fn(&page, last) || last {
+			return nil
+		}
+		in.KeyMarker, in.VersionIdMarker = res.NextKeyMarker, res.NextVersionIdMarker
+	}
+}
+
+var listingConf struct {
+	shardDepth      int
+	shardDelimiters []string
+	startAfter      string
+	maxKeys         int
+}
+
+// errStopWalk is returned by a shard listing when the walk callback asked to stop.
+var errStopWalk = errors.New("walk stopped")
+
+// shardDelimiter returns the delimiter splitting the prefixes of the given depth, the last one repeats.
+func shardDelimiter(depth int) string {
+	d := listingConf.shardDelimiters
+	if depth < len(d) {
+		return d[depth]
+	}
+	return d[len(d)-1]
+}
+
+// walk shards the listing of prefix with --shard-depth levels of sub-prefixes and lists the shards
+// concurrently with --workers. list pages through the prefix with the delimiter, none for the leaf shards,
+// and returns the common prefixes it found. Every level uses a single delimiter, so the objects listed
+// directly under a prefix and the common prefixes under it don't overlap and no object is listed twice.
+func walk(ctx context.Context, prefix string, list func(ctx context.Context, prefix, delimiter string) ([]string, error)) error {
+	if listingConf.shardDepth <= 0 || len(listingConf.shardDelimiters) == 0 {
+		_, err := list(ctx, prefix, "")
+		if err == errStopWalk {
+			return nil
+		}
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type shard struct {
+		prefix string
+		depth  int
+	}
+	var (
+		mu       sync.Mutex
+		cond     = sync.NewCond(&mu)
+		queue    = []shard{{prefix: prefix}}
+		pending  = 1
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	wg.Add(globalOpts.workers)
+	for i := 0; i < globalOpts.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 {
+					cond.Wait()
+				}
+				if pending == 0 {
+					mu.Unlock()
+					return
+				}
+				t := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mu.Unlock()
+				var (
+					subPrefixes []string
+					err         error
+				)
+				if ctx.Err() == nil {
+					delimiter := ""
+					if t.depth < listingConf.shardDepth {
+						delimiter = shardDelimiter(t.depth)
+					}
+					subPrefixes, err = list(ctx, t.prefix, delimiter)
+				}
+				mu.Lock()
+				switch {
+				case err == errStopWalk:
+					cancel()
+				case err != nil && firstErr == nil:
+					firstErr = err
+					cancel()
+				}
+				for _, p := range subPrefixes {
+					queue = append(queue, shard{prefix: p, depth: t.depth + 1})
+				}
+				pending += len(subPrefixes) - 1
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// walkObjects lists the objects under input.Prefix like listObjects, sharded with --shard-depth.
+// --start-after applies unless input has its own StartAfter, and the walk stops once --max-keys objects
+// were passed to fn. The continuation token of input is used only if the listing isn't sharded.
+// fn is called for one page at a time, last tells if it's the last page of its shard,
+// the pages of different shards come in no particular order.
+func walkObjects(ctx context.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	var (
+		mu        sync.Mutex
+		done      bool
+		remaining = listingConf.maxKeys
+	)
+	return walk(ctx, aws.StringValue(input.Prefix), func(ctx context.Context, prefix, delimiter string) ([]string, error) {
+		in := *input
+		if listingConf.shardDepth > 0 {
+			in.ContinuationToken = nil
+		}
+		if in.StartAfter == nil && listingConf.startAfter != "" {
+			in.StartAfter = aws.String(listingConf.startAfter)
+		}
+		if max := int64(listingConf.maxKeys); max > 0 && (in.MaxKeys == nil || *in.MaxKeys > max) {
+			in.MaxKeys = aws.Int64(max)
+		}
+		in.Prefix = aws.String(prefix)
+		if delimiter != "" {
+			in.Delimiter = aws.String(delimiter)
+		}
+		var (
+			subPrefixes []string
+			stopped     bool
+		)
+		err := listObjects(ctx, &in, func(res *s3.ListObjectsV2Output, last bool) bool {
+			for _, p := range res.CommonPrefixes {
+				subPrefixes = append(subPrefixes, *p.Prefix)
+			}
+			page := *res
+			page.CommonPrefixes = nil
+			mu.Lock()
+			defer mu.Unlock()
+			// another shard may have stopped the walk while this page was listed
+			if done {
+				stopped = true
+				return false
+			}
+			capped := false
+			if listingConf.maxKeys > 0 && len(page.Contents) >= remaining {
+				page.Contents, capped = page.Contents[:remaining], true
+				if n := len(page.Contents); n > 0 {
+					log.Infof("stopped listing s3://%s/%s at --max-keys %d, the last key is %s",
+						aws.StringValue(in.Bucket), aws.StringValue(input.Prefix), listingConf.maxKeys, *page.Contents[n-1].Key)
+				}
+			}
+			remaining -= len(page.Contents)
+			done = !fn(&page, last) || capped
+			stopped = done
+			return !stopped
+		})
+		if stopped {
+			return nil, errStopWalk
+		}
+		return subPrefixes, err
+	})
+}
+
+// walkVersions lists the versions under input.Prefix like listObjectVersions, sharded with --shard-depth,
+// and filters them with --include, --exclude, --match and the age and size predicates.
+// The markers of input are used only if the listing isn't sharded. fn is called for one page at a time,
+// shard describes the listing the page comes from and is empty if the listing isn't sharded.
+// The pages of different shards come in no particular order.
+func walkVersions(ctx context.Context, input *s3.ListObjectVersionsInput, fn func(shard string, res *s3.ListObjectVersionsOutput, last bool) bool) error {
+	return walkVersionPages(ctx, input, filterVersions, fn)
+}
+
+// walkVersionPages is walkVersions with the filter of listObjectVersions.
+func walkVersionPages(ctx context.Context, input *s3.ListObjectVersionsInput, filter func([]*s3.ObjectVersion) []*s3.ObjectVersion,
+	fn func(shard string, res *s3.ListObjectVersionsOutput, last bool) bool) error {
+	var (
+		mu   sync.Mutex
+		done bool
+	)
+	return walk(ctx, aws.StringValue(input.Prefix), func(ctx context.Context, prefix, delimiter string) ([]string, error) {
+		in := *input
+		shard := ""
+		if listingConf.shardDepth > 0 {
+			in.KeyMarker, in.VersionIdMarker = nil, nil
+			shard = fmt.Sprintf("%s|%s", prefix, delimiter)
+		}
+		in.Prefix = aws.String(prefix)
+		if delimiter != "" {
+			in.Delimiter = aws.String(delimiter)
+		}
+		var (
+			subPrefixes []string
+			stopped     bool
+		)
+		err := listObjectVersions(ctx, &in, filter, func(res *s3.ListObjectVersionsOutput, last bool) bool {
+			for _, p := range res.CommonPrefixes {
+				subPrefixes = append(subPrefixes, *p.Prefix)
+			}
+			page := *res
+			page.CommonPrefixes = nil
+			mu.Lock()
+			defer mu.Unlock()
+			// another shard may have stopped the walk while this page was listed
+			if !done {
+				done = !fn(shard, &page, last)
+			}
+			stopped = done
+			return !stopped
+		})
+		if stopped {
+			return nil, errStopWalk
+		}
+		return subPrefixes, err
+	})
+}
+
+func init() {
+	pf := rootCmd.PersistentFlags()
+	pf.IntVar(&listingConf.shardDepth, "shard-depth", 0, "list the sub-prefixes up to the depth concurrently, for very large buckets")
+	pf.StringSliceVar(&listingConf.shardDelimiters, "shard-delimiter", []string{"/"}, "delimiters splitting the prefixes of every shard level, the last one repeats")
+}
+
+// initRangeFlags registers the flags selecting a key range of the objects listing.
+func initRangeFlags(f *pflag.FlagSet) {
+	f.StringVar(&listingConf.startAfter, "start-after", "", "list the keys after the given one in lexicographic order")
+	f.IntVar(&listingConf.maxKeys, "max-keys", 0, "stop after listing that many objects of every URL, 0 is no limit")
+}
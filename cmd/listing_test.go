@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jdevelop/s3kit/s3mem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalk(t *testing.T) {
+	b := s3mem.New()
+	_, err := b.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	keys := []string{"p/a", "p/b/1", "p/b/2", "p/c-d", "p/c/1/x", "p/c/1/y", "p/c/2", "p/c=1/z", "p/d/"}
+	for _, k := range keys {
+		_, err := b.Put("bucket", k, []byte(k), time.Now())
+		require.NoError(t, err)
+	}
+	useS3(b)
+	defer func() { listingConf.shardDepth, listingConf.shardDelimiters = 0, []string{"/"} }()
+
+	for _, sharding := range []struct {
+		depth      int
+		delimiters []string
+	}{
+		{0, []string{"/"}},
+		{1, []string{"/"}},
+		{3, []string{"/"}},
+		{2, []string{"/", "="}},
+	} {
+		listingConf.shardDepth, listingConf.shardDelimiters = sharding.depth, sharding.delimiters
+		var objects, versions []string
+		require.NoError(t, walkObjects(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:  aws.String("bucket"),
+			Prefix:  aws.String("p/"),
+			MaxKeys: aws.Int64(1),
+		}, func(res *s3.ListObjectsV2Output, _ bool) bool {
+			for _, o := range res.Contents {
+				objects = append(objects, *o.Key)
+			}
+			return true
+		}))
+		require.NoError(t, walkVersions(context.Background(), &s3.ListObjectVersionsInput{
+			Bucket:  aws.String("bucket"),
+			Prefix:  aws.String("p/"),
+			MaxKeys: aws.Int64(2),
+		}, func(shard string, res *s3.ListObjectVersionsOutput, _ bool) bool {
+			require.Equal(t, sharding.depth == 0, shard == "")
+			for _, v := range res.Versions {
+				versions = append(versions, *v.Key)
+			}
+			return true
+		}))
+		sort.Strings(objects)
+		sort.Strings(versions)
+		require.Equal(t, keys, objects, "depth %d", sharding.depth)
+		require.Equal(t, keys, versions, "depth %d", sharding.depth)
+	}
+
+	listingConf.shardDepth = 2
+	var pages int
+	require.NoError(t, walkObjects(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String("bucket"),
+		Prefix:  aws.String("p/"),
+		MaxKeys: aws.Int64(1),
+	}, func(*s3.ListObjectsV2Output, bool) bool {
+		pages++
+		return false
+	}))
+	require.Equal(t, 1, pages, "the walk stops when fn returns false")
+}
+
+func TestKeyRange(t *testing.T) {
+	b := s3mem.New()
+	_, err := b.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	keys := []string{"logs/2020-01-01-10-00-00-A", "logs/2020-01-02-10-00-00-B", "logs/2020-01-03-10-00-00-C", "logs/2020-01-04-10-00-00-D"}
+	for _, k := range keys {
+		_, err := b.Put("bucket", k, []byte(k), time.Now())
+		require.NoError(t, err)
+	}
+	useS3(b)
+	defer func() {
+		listingConf.shardDepth, listingConf.startAfter, listingConf.maxKeys = 0, "", 0
+		logsConfig.startDate = flagTime{}
+	}()
+
+	list := func() []string {
+		var objects []string
+		require.NoError(t, walkObjects(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:  aws.String("bucket"),
+			Prefix:  aws.String("logs/"),
+			MaxKeys: aws.Int64(1),
+		}, func(res *s3.ListObjectsV2Output, _ bool) bool {
+			for _, o := range res.Contents {
+				objects = append(objects, *o.Key)
+			}
+			return true
+		}))
+		sort.Strings(objects)
+		return objects
+	}
+	listingConf.startAfter = keys[1]
+	require.Equal(t, keys[2:], list())
+	listingConf.startAfter, listingConf.maxKeys = "", 3
+	require.Equal(t, keys[:3], list())
+	listingConf.shardDepth = 1
+	require.Len(t, list(), 3, "the limit is shared by the shards")
+
+	listingConf.shardDepth, listingConf.maxKeys = 0, 0
+	startAfter, err := logsStartAfter(context.Background(), "bucket", "logs/")
+	require.NoError(t, err)
+	require.Nil(t, startAfter, "no --start")
+	require.NoError(t, logsConfig.startDate.Set("2020-01-03"))
+	startAfter, err = logsStartAfter(context.Background(), "bucket", "logs/")
+	require.NoError(t, err)
+	require.Equal(t, "logs/2020-01-03", aws.StringValue(startAfter))
+	startAfter, err = logsStartAfter(context.Background(), "bucket", "logs/2020-")
+	require.NoError(t, err)
+	require.Nil(t, startAfter, "the prefix is a part of the date")
+}
@@ -8,5 +8,6 @@ var lockRoot = &cobra.Command{
 }
 
 func init() {
+	initCheckpointConfig(lockRoot.PersistentFlags())
 	rootCmd.AddCommand(lockRoot)
 }
@@ -1,12 +1,19 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/jdevelop/s3kit/model"
 	"github.com/jdevelop/s3kit/parser"
 	"github.com/spf13/cobra"
@@ -19,81 +26,194 @@ type batch struct {
 	objects []*s3.Object
 }
 
+// logRecord is a record of 'logs' output.
+type logRecord model.S3AccessLogSimple
+
+var logRecordHeader = []string{"Time", "Remote IP", "Operation", "Key", "Request URI", "HTTP Status",
+	"Bytes Sent", "Object Size", "Flight Time", "Turnaround Time", "Referer", "User Agent"}
+
+func (l logRecord) rows() [][]string {
+	return [][]string{{
+		l.Time.Format(time.RFC3339),
+		l.RemoteIP.String(),
+		l.Operation,
+		l.Key,
+		l.RequestURI,
+		strconv.FormatUint(uint64(l.HTTPStatus), 10),
+		strconv.FormatUint(uint64(l.BytesSent), 10),
+		strconv.FormatUint(uint64(l.ObjectSize), 10),
+		l.FlightTime.String(),
+		l.TurnaroundTime.String(),
+		l.Referer,
+		l.UserAgent,
+	}}
+}
+
 var logsCmd = &cobra.Command{
 	Use:          "logs s3://bucket/key1 s3://bucket/prefix/ ...",
 	Short:        "Print S3 Access logs as JSON",
-	Args:         cobra.MinimumNArgs(1),
+	Annotations:  map[string]string{progressAnnotation: progressStdout},
+	Args:         urlArgs,
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		args, err := expandURLs(ctx, args)
+		if err != nil {
+			return err
+		}
 		svc := getS3()
+		lim := getLimiter()
 		batchChan := make(chan batch)
-		mChan := make(chan model.S3AccessLogSimple, 100)
+		mChan := make(chan logRecord, 100)
 		var wg, printer sync.WaitGroup
 		wg.Add(globalOpts.workers)
 		p := parser.NewSimpleParser()
 		printer.Add(1)
 		go func() {
 			defer printer.Done()
-			jsonner := json.NewEncoder(os.Stdout)
+			out := newRenderer(os.Stdout, outputNdjson, logRecordHeader)
 			for v := range mChan {
-				if err := jsonner.Encode(v); err != nil {
+				if err := out.add(v); err != nil {
 					log.Fatalf("can't encode object '%v' => %v", v, err)
 				}
 			}
+			if err := out.close(); err != nil {
+				log.Fatalf("can't print logs => %v", err)
+			}
 		}()
 		for i := 0; i < globalOpts.workers; i++ {
-			go func(svc *s3.S3) {
+			go func(svc s3iface.S3API) {
 				defer wg.Done()
 				for batch := range batchChan {
 					for _, o := range batch.objects {
-						res, err := svc.GetObject(&s3.GetObjectInput{
-							Bucket: &batch.bucket,
-							Key:    o.Key,
-						})
-						if err != nil {
-							log.Errorf("Error reading s3://%s/%s : %+v", batch.bucket, *o.Key, err)
+						if ctx.Err() != nil {
+							break
+						}
+						var res *s3.GetObjectOutput
+						if err := lim.do(ctx, func() (err error) {
+							res, err = svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+								Bucket: &batch.bucket,
+								Key:    o.Key,
+							})
+							return err
+						}); err != nil {
+							if ctx.Err() == nil {
+								log.Errorf("Error reading s3://%s/%s : %+v", batch.bucket, *o.Key, err)
+								atomic.AddUint64(&progress.errors, 1)
+							}
 							continue
 						}
-						if err := p.ParseSimple(res.Body, func(m *model.S3AccessLogSimple) bool {
+						if err := p.ParseSimple(countingReader{res.Body}, func(m *model.S3AccessLogSimple) bool {
 							if m.Time.Before(logsConfig.endDate.Time) && m.Time.After(logsConfig.startDate.Time) {
-								mChan <- *m
+								mChan <- logRecord(*m)
 							}
 							return true
-						}); err != nil {
+						}); err != nil && ctx.Err() == nil {
 							log.Errorf("can't process s3://%s/%s => %v", batch.bucket, *o.Key, err)
+							atomic.AddUint64(&progress.errors, 1)
 						}
 						res.Body.Close()
+						atomic.AddUint64(&progress.processed, 1)
 					}
 				}
 			}(svc)
 		}
-		for _, url := range args {
-			bucket, prefix, err := fromS3(url)
-			if err != nil {
-				return err
+		// the listing closes batchChan on every path, so the workers are done once it returns
+		err = func() error {
+			defer close(batchChan)
+			defer listingDone()
+			if manifestConf.path != "" {
+				if err := readManifestBatches(func(bucket string, versions []*s3.ObjectVersion) bool {
+					atomic.AddUint64(&progress.listed, uint64(len(versions)))
+					objects := make([]*s3.Object, len(versions))
+					for i, v := range versions {
+						objects[i] = &s3.Object{Key: v.Key}
+					}
+					select {
+					case batchChan <- batch{
+						bucket:  bucket,
+						objects: objects,
+					}:
+						return true
+					case <-ctx.Done():
+						return false
+					}
+				}); err != nil && ctx.Err() == nil {
+					return err
+				}
 			}
-			if err := svc.ListObjectsPages(&s3.ListObjectsInput{
-				Bucket:  &bucket,
-				Prefix:  &prefix,
-				MaxKeys: &objectsPerPage,
-			}, func(res *s3.ListObjectsOutput, last bool) bool {
-				batchChan <- batch{
-					bucket:  bucket,
-					objects: res.Contents,
+			for _, url := range args {
+				bucket, prefix, err := fromS3(url)
+				if err != nil {
+					return err
+				}
+				startAfter, err := logsStartAfter(ctx, bucket, prefix)
+				if err != nil {
+					return err
+				}
+				if err := walkObjects(ctx, &s3.ListObjectsV2Input{
+					Bucket:     &bucket,
+					Prefix:     &prefix,
+					MaxKeys:    &objectsPerPage,
+					StartAfter: startAfter,
+				}, func(res *s3.ListObjectsV2Output, last bool) bool {
+					select {
+					case batchChan <- batch{
+						bucket:  bucket,
+						objects: res.Contents,
+					}:
+						return true
+					case <-ctx.Done():
+						return false
+					}
+				}); err != nil && ctx.Err() == nil {
+					return err
+				}
+				if ctx.Err() != nil {
+					break
 				}
-				return true
-			}); err != nil {
-				return err
 			}
-		}
-		close(batchChan)
+			return nil
+		}()
 		wg.Wait()
 		close(mChan)
 		printer.Wait()
-		return nil
+		if err != nil {
+			return err
+		}
+		return ctx.Err()
 	},
 }
 
+// accessLogKey matches the keys of the access logs delivered with the simple key format,
+// [TargetPrefix]YYYY-mm-DD-HH-MM-SS-UniqueString, that sort by delivery time.
+var accessLogKey = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}-`)
+
+// logsStartAfter translates --start into the key to list the logs under prefix after, unless --start-after is set.
+// The first key under prefix tells if the logs use the simple key format, other formats are listed from the start.
+func logsStartAfter(ctx context.Context, bucket, prefix string) (*string, error) {
+	if listingConf.startAfter != "" || logsConfig.startDate.IsZero() {
+		return nil, nil
+	}
+	var res *s3.ListObjectsV2Output
+	if err := getLimiter().do(ctx, func() (err error) {
+		res, err = getS3().ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket:  &bucket,
+			Prefix:  &prefix,
+			MaxKeys: aws.Int64(1),
+		})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if len(res.Contents) == 0 || !accessLogKey.MatchString(strings.TrimPrefix(*res.Contents[0].Key, prefix)) {
+		return nil, nil
+	}
+	startAfter := prefix + logsConfig.startDate.Format("2006-01-02")
+	log.Debugf("listing s3://%s/%s after %s", bucket, prefix, startAfter)
+	return &startAfter, nil
+}
+
 var logsConfig = struct {
 	startDate flagTime
 	endDate   flagTime
@@ -105,16 +225,22 @@ type flagTime struct {
 	time.Time
 }
 
+// Set parses a date, or an RFC 3339 time such as 2020-04-10T12:00:00Z.
 func (t *flagTime) Set(value string) error {
 	p, err := time.Parse("2006-01-02", value)
 	if err != nil {
-		return err
+		if p, err = time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("expected YYYY-MM-DD or an RFC 3339 time, got %s", value)
+		}
 	}
 	t.Time = p
 	return nil
 }
 
 func (t *flagTime) String() string {
+	if t.IsZero() {
+		return ""
+	}
 	return t.Time.String()
 }
 
@@ -126,5 +252,6 @@ func init() {
 	pf := logsCmd.PersistentFlags()
 	pf.VarP(&logsConfig.startDate, "start", "s", "start date ( YYYY-MM-DD )")
 	pf.VarP(&logsConfig.endDate, "end", "e", "end date ( YYYY-MM-DD )")
+	initRangeFlags(logsCmd.Flags())
 	rootCmd.AddCommand(logsCmd)
 }
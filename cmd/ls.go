@@ -1,9 +1,17 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var lsCmd = &cobra.Command{
@@ -14,21 +22,113 @@ var lsCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(lsCmd)
 	pf := lsCmd.PersistentFlags()
-	pf.BoolVar(&lsConfig.asJson, "json", false, "JSON output")
-	pf.BoolVar(&lsConfig.asYaml, "yaml", false, "YAML output")
-	pf.BoolVar(&lsConfig.asTable, "table", true, "ASCII table output")
+	pf.BoolVar(&lsConfig.asJson, "json", false, "JSON output, same as --output json")
+	pf.BoolVar(&lsConfig.asYaml, "yaml", false, "YAML output, same as --output yaml")
+	pf.BoolVar(&lsConfig.asTable, "table", false, "ASCII table output, same as --output table, the default")
+	pf.Var(&lsConfig.sortBy, "sort", "order of paths ( key | modified | size | version ) by their newest version, ls tags and locks need --group")
+	pf.BoolVar(&lsConfig.reverse, "reverse", false, "reverse the order of paths")
+}
+
+func initGroupFlag(f *pflag.FlagSet) {
+	f.BoolVar(&lsConfig.group, "group", false, "merge the versions of every path and print the paths in the --sort order once all are listed")
+}
+
+// prepareGroup rejects the ordering flags without --group, the records are streamed in the order they are fetched.
+func prepareGroup() error {
+	if !lsConfig.group && (lsConfig.sortBy != "" || lsConfig.reverse) {
+		return fmt.Errorf("--sort and --reverse require --group")
+	}
+	return nil
 }
 
 var lsConfig struct {
 	asJson  bool
 	asYaml  bool
 	asTable bool
+	sortBy  flagSort
+	reverse bool
+	group   bool
+}
+
+const (
+	sortKey      = "key"
+	sortModified = "modified"
+	sortSize     = "size"
+	sortVersion  = "version"
+)
+
+// flagSort is the --sort order of ls commands, key by default.
+type flagSort string
+
+func (s *flagSort) Set(value string) error {
+	switch value {
+	case sortKey, sortModified, sortSize, sortVersion:
+		*s = flagSort(value)
+		return nil
+	default:
+		return fmt.Errorf("expected one of %s, %s, %s, %s", sortKey, sortModified, sortSize, sortVersion)
+	}
+}
+
+func (s *flagSort) String() string {
+	if *s == "" {
+		return sortKey
+	}
+	return string(*s)
+}
+
+func (s *flagSort) Type() string {
+	return "Order"
+}
+
+// lsFormat is the output format of ls commands unless --output is set.
+func lsFormat() string {
+	switch {
+	case lsConfig.asTable:
+		return outputTable
+	case lsConfig.asJson:
+		return outputJson
+	case lsConfig.asYaml:
+		return outputYaml
+	default:
+		return outputTable
+	}
+}
+
+const timeFormat = "2006-01-02 15:04:05 -0700 MST"
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(timeFormat)
 }
 
 type Version struct {
 	VersionId    string    `json:"version_id" yaml:"version_id"`
 	LastModified time.Time `json:"last_modified" yaml:"last_modified"`
 	Latest       bool      `json:"latest" yaml:"latest"`
+	Size         int64     `json:"size" yaml:"size"`
+}
+
+func newVersion(ver *s3.ObjectVersion) Version {
+	return Version{
+		VersionId:    aws.StringValue(ver.VersionId),
+		LastModified: aws.TimeValue(ver.LastModified),
+		Latest:       aws.BoolValue(ver.IsLatest),
+		Size:         aws.Int64Value(ver.Size),
+	}
+}
+
+// newerThan orders versions newest first, the latest version goes first among equally old ones.
+func (v *Version) newerThan(other *Version) bool {
+	if !v.LastModified.Equal(other.LastModified) {
+		return v.LastModified.After(other.LastModified)
+	}
+	if v.Latest != other.Latest {
+		return v.Latest
+	}
+	return v.VersionId < other.VersionId
 }
 
 type VersionTag struct {
@@ -44,47 +144,188 @@ type VersionLocks struct {
 }
 
 type PathVersion struct {
+	Bucket   string    `json:"bucket" yaml:"bucket"`
 	Path     string    `json:"path" yaml:"path"`
 	Versions []Version `json:"versions" yaml:"versions"`
 	Latest   string    `json:"latest" yaml:"latest"`
 }
 
 type PathVersionTag struct {
+	Bucket   string       `json:"bucket" yaml:"bucket"`
 	Path     string       `json:"path" yaml:"path"`
 	Versions []VersionTag `json:"versions" yaml:"versions"`
 }
 
 type PathVersionLocks struct {
+	Bucket   string         `json:"bucket" yaml:"bucket"`
 	Path     string         `json:"path" yaml:"path"`
 	Versions []VersionLocks `json:"versions" yaml:"versions"`
 }
 
-func mapPathVersions(src map[string]*PathVersion) []*PathVersion {
-	v := make([]*PathVersion, len(src))
-	i := 0
-	for _, val := range src {
-		v[i] = val
-		i++
+var (
+	pathVersionHeader      = []string{"Bucket", "Path", "Version", "Last Modified", "Size", "Latest"}
+	pathVersionTagHeader   = []string{"Bucket", "Path", "Version", "Tags"}
+	pathVersionLocksHeader = []string{"Bucket", "Path", "Version", "Hold", "Governance", "Compliance"}
+)
+
+func (p *PathVersion) rows() [][]string {
+	rows := make([][]string, len(p.Versions))
+	for i, v := range p.Versions {
+		latest := ""
+		if v.Latest {
+			latest = "*"
+		}
+		rows[i] = []string{p.Bucket, p.Path, v.VersionId, formatTime(&v.LastModified), humanize.Bytes(uint64(v.Size)), latest}
+	}
+	return rows
+}
+
+func (p *PathVersionTag) rows() [][]string {
+	rows := make([][]string, len(p.Versions))
+	for i, v := range p.Versions {
+		rows[i] = []string{p.Bucket, p.Path, v.VersionId, strings.Join(v.Tag, "\n")}
+	}
+	return rows
+}
+
+func (p *PathVersionLocks) rows() [][]string {
+	rows := make([][]string, len(p.Versions))
+	for i, v := range p.Versions {
+		hold := ""
+		if v.LegalHold {
+			hold = "ON"
+		}
+		rows[i] = []string{p.Bucket, p.Path, v.VersionId, hold, formatTime(v.GovernanceRetention), formatTime(v.ComplianceRetention)}
+	}
+	return rows
+}
+
+// pathRecord is a path listed by an ls command.
+type pathRecord interface {
+	record
+	path() string
+	// sortVersions orders the versions of the path newest first and returns the first one.
+	sortVersions() *Version
+	// merge appends the versions of the other record of the same path.
+	merge(other pathRecord)
+}
+
+func (p *PathVersion) path() string      { return p.Path }
+func (p *PathVersionTag) path() string   { return p.Path }
+func (p *PathVersionLocks) path() string { return p.Path }
+
+func (p *PathVersion) merge(other pathRecord) {
+	p.Versions = append(p.Versions, other.(*PathVersion).Versions...)
+}
+
+func (p *PathVersionTag) merge(other pathRecord) {
+	p.Versions = append(p.Versions, other.(*PathVersionTag).Versions...)
+}
+
+func (p *PathVersionLocks) merge(other pathRecord) {
+	p.Versions = append(p.Versions, other.(*PathVersionLocks).Versions...)
+}
+
+func (p *PathVersion) sortVersions() *Version {
+	sort.SliceStable(p.Versions, func(i, j int) bool { return p.Versions[i].newerThan(&p.Versions[j]) })
+	return &p.Versions[0]
+}
+
+func (p *PathVersionTag) sortVersions() *Version {
+	sort.SliceStable(p.Versions, func(i, j int) bool { return p.Versions[i].newerThan(&p.Versions[j].Version) })
+	return &p.Versions[0].Version
+}
+
+func (p *PathVersionLocks) sortVersions() *Version {
+	sort.SliceStable(p.Versions, func(i, j int) bool { return p.Versions[i].newerThan(&p.Versions[j].Version) })
+	return &p.Versions[0].Version
+}
+
+// sortPaths orders the paths by --sort and --reverse, comparing the newest version of every path.
+func sortPaths(paths []pathRecord) {
+	newest := make(map[pathRecord]*Version, len(paths))
+	for _, p := range paths {
+		newest[p] = p.sortVersions()
+	}
+	less := func(a, b pathRecord) bool {
+		va, vb := newest[a], newest[b]
+		switch lsConfig.sortBy {
+		case sortModified:
+			if !va.LastModified.Equal(vb.LastModified) {
+				return va.LastModified.Before(vb.LastModified)
+			}
+		case sortSize:
+			if va.Size != vb.Size {
+				return va.Size < vb.Size
+			}
+		case sortVersion:
+			if va.VersionId != vb.VersionId {
+				return va.VersionId < vb.VersionId
+			}
+		}
+		return a.path() < b.path()
 	}
-	return v
+	sort.Slice(paths, func(i, j int) bool {
+		if lsConfig.reverse {
+			return less(paths[j], paths[i])
+		}
+		return less(paths[i], paths[j])
+	})
 }
 
-func mapPathVersionTags(src map[string]*PathVersionTag) []*PathVersionTag {
-	v := make([]*PathVersionTag, len(src))
-	i := 0
-	for _, val := range src {
-		v[i] = val
-		i++
+// renderPaths prints the paths listed by an ls command in the --sort order,
+// merging the cells of the columns in the table output.
+func renderPaths(paths []pathRecord, header []string, mergeColumns ...int) error {
+	sortPaths(paths)
+	out := newRenderer(os.Stdout, lsFormat(), header)
+	out.mergeColumns(mergeColumns...)
+	for _, p := range paths {
+		if err := out.add(p); err != nil {
+			return err
+		}
 	}
-	return v
+	return out.close()
 }
 
-func mapPathVersionLocks(src map[string]*PathVersionLocks) []*PathVersionLocks {
-	v := make([]*PathVersionLocks, len(src))
-	i := 0
-	for _, val := range src {
-		v[i] = val
-		i++
+// collectPaths prints the paths sent to the returned channel as they come, a record per version.
+// With --group the versions of every path are merged instead and the paths are printed
+// in the --sort order once the channel is closed.
+// The returned function closes the channel and waits for the output to complete.
+func collectPaths(header []string, mergeColumns ...int) (chan<- pathRecord, func() error) {
+	paths := make(chan pathRecord, 100)
+	done := make(chan error, 1)
+	go func() {
+		if lsConfig.group {
+			var (
+				keys   = make(map[string]pathRecord)
+				merged []pathRecord
+			)
+			for p := range paths {
+				if prev, ok := keys[p.path()]; ok {
+					prev.merge(p)
+				} else {
+					keys[p.path()] = p
+					merged = append(merged, p)
+				}
+			}
+			done <- renderPaths(merged, header, mergeColumns...)
+			return
+		}
+		out := newRenderer(os.Stdout, lsFormat(), header)
+		err := out.streamRows()
+		for p := range paths {
+			// keep receiving after an error so the senders don't block
+			if err == nil {
+				err = out.add(p)
+			}
+		}
+		if err == nil {
+			err = out.close()
+		}
+		done <- err
+	}()
+	return paths, func() error {
+		close(paths)
+		return <-done
 	}
-	return v
 }
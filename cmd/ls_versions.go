@@ -1,15 +1,9 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"strings"
-
-	"gopkg.in/yaml.v2"
 
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
@@ -17,91 +11,49 @@ var lsVersions = &cobra.Command{
 	Use:   "versions s3://bucket/folder/ s3://bucket/folder/prefix ...",
 	Short: "List object version(s)",
 	Args:  cobra.MinimumNArgs(1),
-	RunE: func(_ *cobra.Command, urls []string) error {
-		svc := getS3()
-		var renderF func(map[string]*PathVersion) error
-		switch {
-		case lsConfig.asJson:
-			renderF = func(keymap map[string]*PathVersion) error {
-				return json.NewEncoder(os.Stdout).Encode(mapPathVersions(keymap))
-			}
-		case lsConfig.asYaml:
-			renderF = func(keymap map[string]*PathVersion) error {
-				return yaml.NewEncoder(os.Stdout).Encode(mapPathVersions(keymap))
-			}
-		default:
-			renderF = func(keymap map[string]*PathVersion) error {
-				table := tablewriter.NewWriter(os.Stdout)
-				table.SetHeader([]string{"Path", "Version", "Last Modified", "Latest"})
-				table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_CENTER, tablewriter.ALIGN_CENTER})
-				var verString, timeString, latestString strings.Builder
-				for _, row := range keymap {
-					verString.WriteString(row.Versions[0].VersionId)
-					timeString.WriteString(row.Versions[0].LastModified.Format("2006-01-02 15:04:05 -0700 MST"))
-					if row.Versions[0].Latest {
-						latestString.WriteString("*")
-					} else {
-						latestString.WriteString(" ")
-					}
-					for _, v := range row.Versions[1:] {
-						verString.WriteString("\n")
-						verString.WriteString(v.VersionId)
-						timeString.WriteString("\n")
-						timeString.WriteString(v.LastModified.Format("2006-01-02 15:04:05 -0700 MST"))
-						latestString.WriteString("\n")
-						if v.Latest {
-							latestString.WriteString("*")
-						} else {
-							latestString.WriteString(" ")
-						}
-					}
-					table.Append([]string{row.Path, verString.String(), timeString.String(), latestString.String()})
-					verString.Reset()
-					latestString.Reset()
-					timeString.Reset()
-				}
-				table.Render()
-				return nil
-			}
+	RunE: func(cmd *cobra.Command, urls []string) error {
+		urls, err := expandURLs(cmd.Context(), urls)
+		if err != nil {
+			return err
 		}
+		// the versions of all URLs, they may belong to different buckets
+		keysMap := make(map[string]*PathVersion)
 		for _, url := range urls {
 			bucket, prefix, err := fromS3(url)
 			if err != nil {
 				return err
 			}
-			keysMap := make(map[string]*PathVersion)
-			if err := svc.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+			if err := walkVersions(cmd.Context(), &s3.ListObjectVersionsInput{
 				Bucket: &bucket,
 				Prefix: &prefix,
-			}, func(res *s3.ListObjectVersionsOutput, last bool) bool {
+			}, func(_ string, res *s3.ListObjectVersionsOutput, last bool) bool {
 				for _, ver := range res.Versions {
-					if v, ok := keysMap[*ver.Key]; ok {
-						v.Versions = append(v.Versions, Version{
-							VersionId:    *ver.VersionId,
-							LastModified: *ver.LastModified,
-							Latest:       *ver.IsLatest,
-						})
-						if *ver.IsLatest {
-							v.Latest = *ver.VersionId
-						}
+					path := fmt.Sprintf("s3://%s/%s", bucket, *ver.Key)
+					v, ok := keysMap[path]
+					if ok {
+						v.Versions = append(v.Versions, newVersion(ver))
 					} else {
 						v = &PathVersion{
-							Path:     fmt.Sprintf("s3://%s/%s", bucket, *ver.Key),
-							Versions: []Version{{VersionId: *ver.VersionId, LastModified: *ver.LastModified, Latest: *ver.IsLatest}},
+							Bucket:   bucket,
+							Path:     path,
+							Versions: []Version{newVersion(ver)},
 						}
-						if *ver.IsLatest {
-							v.Latest = *ver.VersionId
-						}
-						keysMap[*ver.Key] = v
+						keysMap[path] = v
+					}
+					if *ver.IsLatest {
+						v.Latest = *ver.VersionId
 					}
 				}
 				return true
 			}); err != nil {
 				return err
 			}
-			renderF(keysMap)
 		}
-		return nil
+		paths := make([]pathRecord, 0, len(keysMap))
+		for _, v := range keysMap {
+			paths = append(paths, v)
+		}
+		return renderPaths(paths, pathVersionHeader, 0, 1)
 	},
 }
 
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spf13/cobra"
+)
+
+const manifestBatchSize = 1000
+
+var manifestConf struct {
+	path      string
+	inventory bool
+}
+
+// prepareManifest rejects the flags --from-file can't honor: the manifest has no modification times or sizes.
+func prepareManifest() error {
+	if manifestConf.path == "" {
+		if manifestConf.inventory {
+			return fmt.Errorf("--inventory requires --from-file")
+		}
+		return nil
+	}
+	if filterConf.olderThan.set || filterConf.newerThan.set || !filterConf.modifiedBefore.IsZero() || !filterConf.modifiedAfter.IsZero() ||
+		filterConf.minSize.set || filterConf.maxSize.set {
+		return fmt.Errorf("--from-file can't be used with --older-than, --newer-than, --modified-before, --modified-after, --min-size or --max-size")
+	}
+	return nil
+}
+
+// urlArgs requires at least one S3 URL unless objects are read from a manifest.
+func urlArgs(cmd *cobra.Command, args []string) error {
+	if manifestConf.path != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--from-file can't be combined with S3 URLs")
+		}
+		return nil
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
+
+// manifestEntry is an object listed in a manifest, VersionId is empty for the current version.
+// Latest tells if the version is the current one, nil when the manifest doesn't say.
+type manifestEntry struct {
+	Bucket    string
+	Key       string
+	VersionId string
+	Latest    *bool
+}
+
+// manifestRecord is a JSON manifest record: either bucket, key and version_id fields,
+// or a path with optional versions as printed by 'ls versions/tags/locks --json'.
+// The latest field of a path is the version id of its latest version, the one of a version tells if it is the latest.
+type manifestRecord struct {
+	Bucket    string          `json:"bucket"`
+	Key       string          `json:"key"`
+	VersionId string          `json:"version_id"`
+	Latest    json.RawMessage `json:"latest"`
+	Path      string          `json:"path"`
+	Versions  []struct {
+		VersionId string `json:"version_id"`
+		Latest    *bool  `json:"latest"`
+		Version   struct {
+			VersionId string `json:"version_id"`
+			Latest    *bool  `json:"latest"`
+		} `json:"version"`
+	} `json:"versions"`
+}
+
+// latest returns the latest field of the record, either a flag or the version id of the latest version.
+func (r *manifestRecord) latest() (flag *bool, versionId string) {
+	if len(r.Latest) == 0 {
+		return nil, ""
+	}
+	var b bool
+	if json.Unmarshal(r.Latest, &b) == nil {
+		return &b, ""
+	}
+	json.Unmarshal(r.Latest, &versionId)
+	return nil, versionId
+}
+
+func (r *manifestRecord) entries() ([]manifestEntry, error) {
+	latest, latestId := r.latest()
+	if r.Path == "" {
+		if r.Bucket == "" || r.Key == "" {
+			return nil, fmt.Errorf("manifest record has neither path nor bucket and key")
+		}
+		return []manifestEntry{{Bucket: r.Bucket, Key: r.Key, VersionId: r.VersionId, Latest: latest}}, nil
+	}
+	bucket, key, err := fromS3(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Versions) == 0 {
+		return []manifestEntry{{Bucket: bucket, Key: key, VersionId: r.VersionId, Latest: latest}}, nil
+	}
+	entries := make([]manifestEntry, len(r.Versions))
+	for i, v := range r.Versions {
+		e := manifestEntry{Bucket: bucket, Key: key, VersionId: v.VersionId, Latest: v.Latest}
+		if v.VersionId == "" {
+			e.VersionId, e.Latest = v.Version.VersionId, v.Version.Latest
+		}
+		if e.Latest == nil && latestId != "" {
+			e.Latest = aws.Bool(e.VersionId == latestId)
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+// parseCSVRecord parses 'bucket,key[,versionId]' and 's3://bucket/key[,versionId]' records.
+// With --inventory the records are of an S3 Inventory report: the keys are URL-encoded,
+// and the is_latest and is_delete_marker columns follow the version id.
+func parseCSVRecord(rec []string) (*manifestEntry, error) {
+	switch {
+	case strings.HasPrefix(rec[0], "s3://"):
+		bucket, key, err := fromS3(rec[0])
+		if err != nil {
+			return nil, err
+		}
+		e := &manifestEntry{Bucket: bucket, Key: key}
+		if len(rec) > 1 {
+			e.VersionId = rec[1]
+		}
+		return e, nil
+	case len(rec) < 2:
+		return nil, fmt.Errorf("expected bucket,key[,versionId] or s3:// URL, got '%s'", strings.Join(rec, ","))
+	case rec[0] == "bucket" && rec[1] == "key":
+		// header
+		return nil, nil
+	case !manifestConf.inventory:
+		e := &manifestEntry{Bucket: rec[0], Key: rec[1]}
+		if len(rec) > 2 {
+			e.VersionId = rec[2]
+		}
+		return e, nil
+	default:
+		key, err := url.QueryUnescape(rec[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL-encoded key '%s': %v", rec[1], err)
+		}
+		e := &manifestEntry{Bucket: rec[0], Key: key}
+		if len(rec) > 2 {
+			e.VersionId = rec[2]
+		}
+		if len(rec) > 3 {
+			if latest, err := strconv.ParseBool(rec[3]); err == nil {
+				e.Latest = aws.Bool(latest)
+			}
+		}
+		if len(rec) > 4 && rec[4] == "true" {
+			// a delete marker has no content to process
+			return nil, nil
+		}
+		return e, nil
+	}
+}
+
+// readManifest calls fn for every object of the manifest until fn returns false.
+// The manifest is either CSV, JSON lines or a JSON array, the format is detected by the first character.
+func readManifest(src io.Reader, fn func(manifestEntry) bool) error {
+	r := bufio.NewReader(src)
+	first, err := skipSpace(r)
+	switch {
+	case err == io.EOF:
+		return nil
+	case err != nil:
+		return err
+	case first == '[' || first == '{':
+		dec := json.NewDecoder(r)
+		if first == '[' {
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+		}
+		for dec.More() {
+			var rec manifestRecord
+			if err := dec.Decode(&rec); err != nil {
+				return err
+			}
+			entries, err := rec.entries()
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if !fn(e) {
+					return nil
+				}
+			}
+		}
+		return nil
+	default:
+		records := csv.NewReader(r)
+		records.FieldsPerRecord = -1
+		records.Comment = '#'
+		for {
+			rec, err := records.Read()
+			switch {
+			case err == io.EOF:
+				return nil
+			case err != nil:
+				return err
+			}
+			e, err := parseCSVRecord(rec)
+			if err != nil {
+				return err
+			}
+			if e != nil && !fn(*e) {
+				return nil
+			}
+		}
+	}
+}
+
+func skipSpace(r *bufio.Reader) (rune, error) {
+	for {
+		c, _, err := r.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(c) && c != '\uFEFF' {
+			return c, r.UnreadRune()
+		}
+	}
+}
+
+// openManifest opens the --from-file manifest, '-' stands for stdin.
+func openManifest() (io.ReadCloser, error) {
+	if manifestConf.path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(manifestConf.path)
+}
+
+// readManifestBatches groups consecutive manifest objects of the same bucket that pass the key filters into batches.
+// The versions have IsLatest set when the manifest refers to the current version of the object or tells
+// whether the version is the latest one, otherwise it is not known.
+func readManifestBatches(fn func(bucket string, versions []*s3.ObjectVersion) bool) error {
+	src, err := openManifest()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	var (
+		bucket   string
+		versions []*s3.ObjectVersion
+		stopped  bool
+	)
+	if err := readManifest(src, func(e manifestEntry) bool {
+		if !matchKey(e.Key) {
+			return true
+		}
+		if len(versions) > 0 && (e.Bucket != bucket || len(versions) == manifestBatchSize) {
+			if stopped = !fn(bucket, versions); stopped {
+				return false
+			}
+			versions = nil
+		}
+		bucket = e.Bucket
+		v := &s3.ObjectVersion{Key: aws.String(e.Key)}
+		if e.VersionId != "" {
+			v.VersionId, v.IsLatest = aws.String(e.VersionId), e.Latest
+		} else {
+			v.IsLatest = aws.Bool(true)
+		}
+		versions = append(versions, v)
+		return true
+	}); err != nil {
+		return err
+	}
+	if len(versions) > 0 && !stopped {
+		fn(bucket, versions)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&manifestConf.path, "from-file", "",
+		"read objects from a CSV ( bucket,key[,versionId] or s3:// URLs ) or JSON lines manifest instead of listing, '-' for stdin")
+	rootCmd.PersistentFlags().BoolVar(&manifestConf.inventory, "inventory", false,
+		"the --from-file CSV is an S3 Inventory report with URL-encoded keys, is_latest and is_delete_marker columns")
+}
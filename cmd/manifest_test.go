@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadManifest(t *testing.T) {
+	defer func() { manifestConf.inventory = false }()
+	for name, tc := range map[string]struct {
+		manifest  string
+		inventory bool
+		entries   []manifestEntry
+	}{
+		"csv": {
+			manifest: "bucket,key,version_id\n# comment\nb1,\"a,b\",v1\nb2,c\ns3://b3/d/e,v3\ns3://b3/f\nb4,a+b%zz%3D,v4\n",
+			entries: []manifestEntry{
+				{Bucket: "b1", Key: "a,b", VersionId: "v1"},
+				{Bucket: "b2", Key: "c"},
+				{Bucket: "b3", Key: "d/e", VersionId: "v3"},
+				{Bucket: "b3", Key: "f"},
+				{Bucket: "b4", Key: "a+b%zz%3D", VersionId: "v4"},
+			},
+		},
+		"inventory": {
+			inventory: true,
+			manifest:  "\"b1\",\"dir/a+b%3D1.csv\",\"v2\",\"true\",\"false\"\n\"b1\",\"dir/a+b%3D1.csv\",\"v1\",\"false\",\"false\"\n\"b1\",\"dir/c\",\"v3\",\"true\",\"true\"\n",
+			entries: []manifestEntry{
+				{Bucket: "b1", Key: "dir/a b=1.csv", VersionId: "v2", Latest: aws.Bool(true)},
+				{Bucket: "b1", Key: "dir/a b=1.csv", VersionId: "v1", Latest: aws.Bool(false)},
+			},
+		},
+		"json lines": {
+			manifest: `{"bucket":"b1","key":"a","version_id":"v1"}
+{"path":"s3://b2/c"}
+`,
+			entries: []manifestEntry{
+				{Bucket: "b1", Key: "a", VersionId: "v1"},
+				{Bucket: "b2", Key: "c"},
+			},
+		},
+		"ls output": {
+			manifest: ` [{"path":"s3://b1/a","versions":[{"version_id":"v1"},{"version_id":"v2"}],"latest":"v2"},
+{"path":"s3://b1/c","versions":[{"version":{"version_id":"v3","latest":true},"tags":["k=v"]}]},
+{"bucket":"b1","key":"d","version_id":"v4","latest":false}]`,
+			entries: []manifestEntry{
+				{Bucket: "b1", Key: "a", VersionId: "v1", Latest: aws.Bool(false)},
+				{Bucket: "b1", Key: "a", VersionId: "v2", Latest: aws.Bool(true)},
+				{Bucket: "b1", Key: "c", VersionId: "v3", Latest: aws.Bool(true)},
+				{Bucket: "b1", Key: "d", VersionId: "v4", Latest: aws.Bool(false)},
+			},
+		},
+		"empty": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			manifestConf.inventory = tc.inventory
+			var entries []manifestEntry
+			require.NoError(t, readManifest(strings.NewReader(tc.manifest), func(e manifestEntry) bool {
+				entries = append(entries, e)
+				return true
+			}))
+			require.Equal(t, tc.entries, entries)
+		})
+	}
+	require.Error(t, readManifest(strings.NewReader("just-a-key\n"), func(manifestEntry) bool { return true }))
+}
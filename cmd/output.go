@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputTable    = "table"
+	outputJson     = "json"
+	outputNdjson   = "ndjson"
+	outputYaml     = "yaml"
+	outputCsv      = "csv"
+	outputTsv      = "tsv"
+	outputMarkdown = "markdown"
+	outputTemplate = "template"
+)
+
+var outputFormats = []string{outputTable, outputJson, outputNdjson, outputYaml, outputCsv, outputTsv, outputMarkdown, outputTemplate}
+
+var outputConf struct {
+	format   string
+	template string
+	tmpl     *template.Template
+}
+
+// prepareOutput validates --output and parses --template.
+func prepareOutput() error {
+	outputConf.tmpl = nil
+	switch format := outputFormat(""); format {
+	case outputTemplate:
+		if outputConf.template == "" {
+			return fmt.Errorf("--output template requires --template")
+		}
+		text := outputConf.template
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		tmpl, err := template.New("output").Parse(text)
+		if err != nil {
+			return err
+		}
+		outputConf.tmpl = tmpl
+	case "", outputTable, outputJson, outputNdjson, outputYaml, outputCsv, outputTsv, outputMarkdown:
+	default:
+		return fmt.Errorf("unknown output format '%s', expected one of %s", format, strings.Join(outputFormats, ", "))
+	}
+	return nil
+}
+
+// outputFormat returns the format set with --output or implied by --template,
+// otherwise the default of the command, which may come from its own --json / --yaml flags.
+func outputFormat(def string) string {
+	switch {
+	case outputConf.format != "":
+		return outputConf.format
+	case outputConf.template != "":
+		return outputTemplate
+	default:
+		return def
+	}
+}
+
+// record is an item of command output. Structured formats ( json, ndjson, yaml, template ) encode
+// the record itself, tabular formats ( table, csv, tsv, markdown ) print the rows of cells it returns.
+type record interface {
+	rows() [][]string
+}
+
+// renderer prints records in the selected output format as they are added,
+// except the table output that is rendered on close unless streamRows was called.
+type renderer struct {
+	format string
+	w      io.Writer
+	header []string
+	table  *tablewriter.Table
+	csv    *csv.Writer
+	json   *json.Encoder
+	count  int
+	// column widths of the streamed table
+	widths []int
+}
+
+func newRenderer(w io.Writer, def string, header []string) *renderer {
+	r := &renderer{
+		format: outputFormat(def),
+		w:      w,
+		header: header,
+	}
+	switch r.format {
+	case outputTable:
+		r.table = tablewriter.NewWriter(w)
+		r.table.SetHeader(header)
+	case outputCsv, outputTsv:
+		r.csv = csv.NewWriter(w)
+		if r.format == outputTsv {
+			r.csv.Comma = '\t'
+		}
+		r.csv.Write(header)
+	case outputNdjson:
+		r.json = json.NewEncoder(w)
+	case outputMarkdown:
+		r.markdownRow(header)
+		sep := make([]string, len(header))
+		for i := range sep {
+			sep[i] = "---"
+		}
+		r.markdownRow(sep)
+	}
+	return r
+}
+
+// streamRows makes the table output print the rows as they are added, padded to the widest cell
+// seen so far, instead of rendering the whole table on close.
+func (r *renderer) streamRows() error {
+	if r.table == nil {
+		return nil
+	}
+	r.table = nil
+	r.widths = make([]int, len(r.header))
+	return r.paddedRow(r.header)
+}
+
+var cellLines = strings.NewReplacer("\n", ",")
+
+func (r *renderer) paddedRow(cells []string) error {
+	var line strings.Builder
+	for i, c := range cells {
+		c = cellLines.Replace(c)
+		if i == len(cells)-1 {
+			line.WriteString(c)
+			break
+		}
+		if i < len(r.widths) && len(c) > r.widths[i] {
+			r.widths[i] = len(c)
+		}
+		line.WriteString(c)
+		line.WriteString(strings.Repeat(" ", r.widths[i]-len(c)+2))
+	}
+	line.WriteByte('\n')
+	_, err := io.WriteString(r.w, line.String())
+	return err
+}
+
+// mergeColumns merges the equal adjacent cells of the columns in the table output.
+func (r *renderer) mergeColumns(columns ...int) {
+	if r.table != nil {
+		r.table.SetAutoMergeCells(true)
+		r.table.SetRowLine(true)
+		r.table.SetAutoMergeCellsByColumnIndex(columns)
+	}
+}
+
+// footer sets the footer of the table output, other formats have none.
+func (r *renderer) footer(cells []string) {
+	if r.table != nil {
+		r.table.SetFooter(cells)
+	}
+}
+
+func (r *renderer) add(rec record) error {
+	r.count++
+	switch r.format {
+	case outputJson:
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		sep := ","
+		if r.count == 1 {
+			sep = "["
+		}
+		_, err = fmt.Fprintf(r.w, "%s%s", sep, data)
+		return err
+	case outputYaml:
+		data, err := yaml.Marshal([]record{rec})
+		if err != nil {
+			return err
+		}
+		_, err = r.w.Write(data)
+		return err
+	case outputNdjson:
+		return r.json.Encode(rec)
+	case outputTemplate:
+		return outputConf.tmpl.Execute(r.w, rec)
+	case outputTable:
+		if r.table != nil {
+			r.table.AppendBulk(rec.rows())
+			break
+		}
+		for _, row := range rec.rows() {
+			if err := r.paddedRow(row); err != nil {
+				return err
+			}
+		}
+	case outputCsv, outputTsv:
+		if err := r.csv.WriteAll(rec.rows()); err != nil {
+			return err
+		}
+	case outputMarkdown:
+		for _, row := range rec.rows() {
+			if err := r.markdownRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *renderer) close() error {
+	switch r.format {
+	case outputJson:
+		end := "]\n"
+		if r.count == 0 {
+			end = "[]\n"
+		}
+		_, err := io.WriteString(r.w, end)
+		return err
+	case outputYaml:
+		if r.count == 0 {
+			_, err := io.WriteString(r.w, "[]\n")
+			return err
+		}
+	case outputTable:
+		if r.table != nil {
+			r.table.Render()
+		}
+	case outputCsv, outputTsv:
+		r.csv.Flush()
+		return r.csv.Error()
+	}
+	return nil
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\n", "<br>")
+
+func (r *renderer) markdownRow(cells []string) error {
+	escaped := make([]string, len(cells))
+	for i, c := range cells {
+		escaped[i] = markdownEscaper.Replace(c)
+	}
+	_, err := fmt.Fprintf(r.w, "| %s |\n", strings.Join(escaped, " | "))
+	return err
+}
+
+func init() {
+	pf := rootCmd.PersistentFlags()
+	pf.StringVarP(&outputConf.format, "output", "o", "", "output format ( "+strings.Join(outputFormats, " | ")+" ), the default depends on the command")
+	pf.StringVar(&outputConf.template, "template", "", "Go template applied to every output record, e.g. '{{.Path}} {{.Size}}'")
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testRecord struct {
+	Path string `json:"path" yaml:"path"`
+	Size int    `json:"size" yaml:"size"`
+}
+
+func (r testRecord) rows() [][]string {
+	return [][]string{{r.Path, "a|b\nc"}}
+}
+
+func TestRenderer(t *testing.T) {
+	defer func() { outputConf.format, outputConf.template = "", "" }()
+	for format, expected := range map[string]string{
+		outputJson:     `[{"path":"s3://b/k1","size":1},{"path":"s3://b/k2","size":2}]` + "\n",
+		outputNdjson:   `{"path":"s3://b/k1","size":1}` + "\n" + `{"path":"s3://b/k2","size":2}` + "\n",
+		outputYaml:     "- path: s3://b/k1\n  size: 1\n- path: s3://b/k2\n  size: 2\n",
+		outputCsv:      "Path,Value\ns3://b/k1,\"a|b\nc\"\ns3://b/k2,\"a|b\nc\"\n",
+		outputTsv:      "Path\tValue\ns3://b/k1\t\"a|b\nc\"\ns3://b/k2\t\"a|b\nc\"\n",
+		outputMarkdown: "| Path | Value |\n| --- | --- |\n| s3://b/k1 | a\\|b<br>c |\n| s3://b/k2 | a\\|b<br>c |\n",
+		outputTemplate: "s3://b/k1 1\ns3://b/k2 2\n",
+	} {
+		t.Run(format, func(t *testing.T) {
+			outputConf.format, outputConf.template = format, "{{.Path}} {{.Size}}"
+			require.NoError(t, prepareOutput())
+			var buf bytes.Buffer
+			out := newRenderer(&buf, outputTable, []string{"Path", "Value"})
+			require.NoError(t, out.add(testRecord{"s3://b/k1", 1}))
+			require.NoError(t, out.add(testRecord{"s3://b/k2", 2}))
+			require.NoError(t, out.close())
+			require.Equal(t, expected, buf.String())
+		})
+	}
+	outputConf.format = "xml"
+	require.Error(t, prepareOutput())
+}
@@ -1,19 +1,18 @@
 package cmd
 
 import (
-	"context"
-	"encoding/json"
 	"os"
-	"strings"
 
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 	ps3 "github.com/xitongsys/parquet-go-source/s3"
 	parquet "github.com/xitongsys/parquet-go/parquet"
 	"github.com/xitongsys/parquet-go/reader"
 )
 
+// parquetExcludes are the files that Spark and Hadoop write next to parquet files.
+var parquetExcludes = []string{"_SUCCESS", "*.crc"}
+
 var parquetCmd = &cobra.Command{
 	Use:   "parquet",
 	Short: "Parquet files explorer",
@@ -22,13 +21,15 @@ var parquetSchema = &cobra.Command{
 	Use:   "schema s3://bucket/prefix/key s3://bucket/prefix/ ...",
 	Short: "Print parquet files schema",
 	Args:  cobra.MinimumNArgs(1),
-	RunE: func(_ *cobra.Command, urls []string) error {
+	RunE: func(cmd *cobra.Command, urls []string) error {
+		urls, err := expandURLs(cmd.Context(), urls)
+		if err != nil {
+			return err
+		}
 		ps3.SetActiveSession(getSession())
-		var printFunc func([]*parquet.SchemaElement) error
+		def := outputTable
 		if parquetConf.isJson {
-			printFunc = printSchemaJson
-		} else {
-			printFunc = printSchemaTableSimple
+			def = outputJson
 		}
 		for _, url := range urls {
 			log.Debugf("processing %s", url)
@@ -37,19 +38,18 @@ var parquetSchema = &cobra.Command{
 				return err
 			}
 			var processed int
-			svc := getS3()
-			if err := svc.ListObjectsPages(&s3.ListObjectsInput{
+			if err := walkObjects(cmd.Context(), &s3.ListObjectsV2Input{
 				Bucket: &bucket,
 				Prefix: &key,
-			}, func(res *s3.ListObjectsOutput, last bool) bool {
+			}, func(res *s3.ListObjectsV2Output, last bool) bool {
 				for _, obj := range res.Contents {
-					if strings.HasSuffix(*obj.Key, "_SUCCESS") || strings.HasSuffix(*obj.Key, ".crc") {
+					if anyGlobMatch(parquetExcludes, *obj.Key) {
 						continue
 					}
 					if processed >= parquetConf.maxKeys {
 						return false
 					}
-					pf, err := ps3.NewS3FileReader(context.TODO(), bucket, *obj.Key)
+					pf, err := ps3.NewS3FileReader(cmd.Context(), bucket, *obj.Key)
 					if err != nil {
 						log.Errorf("can't open file s3://%s/%s : %+v", bucket, *obj.Key, err)
 						return true
@@ -59,7 +59,10 @@ var parquetSchema = &cobra.Command{
 						log.Errorf("can't create reader from s3://%s/%s : %+v", bucket, *obj.Key, err)
 						return true
 					}
-					printFunc(r.Footer.Schema)
+					if err := printSchema(def, r.Footer.Schema); err != nil {
+						log.Errorf("can't print schema of s3://%s/%s : %+v", bucket, *obj.Key, err)
+						return false
+					}
 					processed += 1
 				}
 				return true
@@ -71,23 +74,26 @@ var parquetSchema = &cobra.Command{
 	},
 }
 
-func printSchemaJson(schema []*parquet.SchemaElement) error {
-	encoder := json.NewEncoder(os.Stdout)
-	return encoder.Encode(schema)
+// schemaElement is a record of 'parquet schema' output.
+type schemaElement struct {
+	*parquet.SchemaElement
+}
+
+func (s schemaElement) rows() [][]string {
+	if s.Type != nil {
+		return [][]string{{s.Name, s.Type.String()}}
+	}
+	return [][]string{{s.Name, "<COMPOSITE>"}}
 }
 
-func printSchemaTableSimple(schema []*parquet.SchemaElement) error {
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "Type"})
+func printSchema(def string, schema []*parquet.SchemaElement) error {
+	out := newRenderer(os.Stdout, def, []string{"Name", "Type"})
 	for _, s := range schema {
-		if s.Type != nil {
-			table.Append([]string{s.Name, s.Type.String()})
-		} else {
-			table.Append([]string{s.Name, "<COMPOSITE>"})
+		if err := out.add(schemaElement{s}); err != nil {
+			return err
 		}
 	}
-	table.Render()
-	return nil
+	return out.close()
 }
 
 func init() {
@@ -95,7 +101,8 @@ func init() {
 	rootCmd.AddCommand(parquetCmd)
 	pff := parquetSchema.Flags()
 	pff.IntVar(&parquetConf.maxKeys, "keys", 1, "max parquet files to process")
-	pff.BoolVar(&parquetConf.isJson, "json", false, "JSON output")
+	pff.BoolVar(&parquetConf.isJson, "json", false, "JSON output, same as --output json")
+	initRangeFlags(pff)
 }
 
 var parquetConf struct {
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	progressInterval = 500 * time.Millisecond
+	// progressAnnotation marks the commands that show progress, the value tells if they print results to stdout
+	progressAnnotation = "progress"
+	progressStderr     = "stderr"
+	progressStdout     = "stdout"
+)
+
+// progress counts the work of the listings and the worker pools, all fields are updated atomically.
+var progress struct {
+	listed    uint64
+	processed uint64
+	bytes     uint64
+	requests  uint64
+	errors    uint64
+	// set once every object to process was listed, so listed is the total
+	listingDone uint32
+}
+
+func resetProgress() {
+	atomic.StoreUint64(&progress.listed, 0)
+	atomic.StoreUint64(&progress.processed, 0)
+	atomic.StoreUint64(&progress.bytes, 0)
+	atomic.StoreUint64(&progress.requests, 0)
+	atomic.StoreUint64(&progress.errors, 0)
+	atomic.StoreUint32(&progress.listingDone, 0)
+}
+
+func listingDone() {
+	atomic.StoreUint32(&progress.listingDone, 1)
+}
+
+// countingReader adds the bytes read to the progress and the --stats.
+type countingReader struct {
+	io.Reader
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddUint64(&progress.bytes, uint64(n))
+	apiCalls.read(n)
+	return n, err
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// stopProgress prints the final progress line, it's replaced by startProgress.
+var stopProgress = func() {}
+
+// startProgress resets the counters and redraws the progress line on stderr until stopProgress is called.
+// The line is shown only if stderr is a terminal and --quiet is off, and for the commands printing
+// their results to stdout only if stdout is redirected, so the output isn't mixed with it.
+func startProgress(printsToStdout bool) {
+	resetProgress()
+	if globalOpts.quiet || !isTerminal(os.Stderr) || (printsToStdout && isTerminal(os.Stdout)) {
+		return
+	}
+	var (
+		done     = make(chan struct{})
+		finished = make(chan struct{})
+		started  = time.Now()
+	)
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		var lastRequests uint64
+		lastTick := started
+		for {
+			select {
+			case now := <-ticker.C:
+				requests := atomic.LoadUint64(&progress.requests)
+				rate := float64(requests-lastRequests) / now.Sub(lastTick).Seconds()
+				lastRequests, lastTick = requests, now
+				fmt.Fprintf(os.Stderr, "\r\033[K%s", progressLine(rate, now.Sub(started)))
+			case <-done:
+				elapsed := time.Since(started)
+				rate := float64(atomic.LoadUint64(&progress.requests)) / elapsed.Seconds()
+				fmt.Fprintf(os.Stderr, "\r\033[K%s in %s\n", progressLine(rate, elapsed), elapsed.Round(time.Second))
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	stopProgress = func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+func progressLine(rate float64, elapsed time.Duration) string {
+	var (
+		listed    = atomic.LoadUint64(&progress.listed)
+		processed = atomic.LoadUint64(&progress.processed)
+		bytes     = atomic.LoadUint64(&progress.bytes)
+		errors    = atomic.LoadUint64(&progress.errors)
+		parts     = []string{fmt.Sprintf("listed %s", humanize.Comma(int64(listed)))}
+	)
+	if processed > 0 {
+		parts = append(parts, fmt.Sprintf("processed %s", humanize.Comma(int64(processed))))
+	}
+	if bytes > 0 {
+		parts = append(parts, fmt.Sprintf("read %s", humanize.Bytes(bytes)))
+	}
+	parts = append(parts, fmt.Sprintf("%.0f req/s", rate))
+	if errors > 0 {
+		parts = append(parts, fmt.Sprintf("%d errors", errors))
+	}
+	if atomic.LoadUint32(&progress.listingDone) == 1 && processed > 0 && processed < listed {
+		eta := time.Duration(float64(elapsed) / float64(processed) * float64(listed-processed))
+		parts = append(parts, fmt.Sprintf("ETA %s", eta.Round(time.Second)))
+	}
+	return strings.Join(parts, ", ")
+}
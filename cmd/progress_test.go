@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	newBackend(t)
+	resetProgress()
+	require.NoError(t, run(context.Background(), []string{"s3://bucket/data/"}, func(string, *s3.ObjectVersion) error {
+		return nil
+	}))
+	require.Equal(t, "listed 3, processed 3, 2 req/s", progressLine(2, time.Second))
+
+	resetProgress()
+	progress.listed, progress.processed, progress.bytes, progress.errors = 1000, 250, 2000000, 1
+	require.Equal(t, "listed 1,000, processed 250, read 2.0 MB, 10 req/s, 1 errors", progressLine(10, time.Minute))
+	listingDone()
+	require.Equal(t, "listed 1,000, processed 250, read 2.0 MB, 10 req/s, 1 errors, ETA 3m0s", progressLine(10, time.Minute))
+}
@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	l "log"
 	"net/url"
+	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -18,7 +23,28 @@ var rootCmd = &cobra.Command{
 	Use:          "s3kit",
 	Short:        "AWS S3 command line toolkit",
 	SilenceUsage: true,
-	PersistentPreRunE: func(*cobra.Command, []string) error {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		switch globalOpts.failuresFormat {
+		case failuresTable, failuresJson:
+		default:
+			return fmt.Errorf("unknown failures format '%s'", globalOpts.failuresFormat)
+		}
+		if err := prepareFilters(); err != nil {
+			return err
+		}
+		if err := prepareManifest(); err != nil {
+			return err
+		}
+		if err := prepareOutput(); err != nil {
+			return err
+		}
+		if err := prepareStats(); err != nil {
+			return err
+		}
+		if err := prepareSession(); err != nil {
+			return err
+		}
+		prepareCheckpoint(cmd, args)
 		cfg := zap.NewDevelopmentConfig()
 		cfg.EncoderConfig.EncodeTime = zapcore.TimeEncoder(func(time.Time, zapcore.PrimitiveArrayEncoder) {})
 		cfg.EncoderConfig.EncodeCaller = zapcore.CallerEncoder(func(zapcore.EntryCaller, zapcore.PrimitiveArrayEncoder) {})
@@ -37,12 +63,42 @@ var rootCmd = &cobra.Command{
 			return err
 		}
 		log = l.Sugar()
+		if mode, ok := cmd.Annotations[progressAnnotation]; ok {
+			startProgress(mode == progressStdout || globalOpts.dryRun)
+		}
 		return nil
 	},
 }
 
+// interruptedExitCode is the exit code used when the command was stopped by SIGINT or SIGTERM.
+const interruptedExitCode = 130
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-sigs
+		if !ok {
+			return
+		}
+		// the second signal terminates the process right away
+		signal.Stop(sigs)
+		if log != nil {
+			log.Warnf("%s received, waiting for in-flight requests to finish", sig)
+		}
+		cancel()
+	}()
+	err := rootCmd.ExecuteContext(ctx)
+	stopProgress()
+	printStats()
+	signal.Stop(sigs)
+	close(sigs)
+	if err != nil {
+		if ctx.Err() != nil {
+			os.Exit(interruptedExitCode)
+		}
 		if log != nil {
 			log.Fatal(err)
 		} else {
@@ -51,29 +107,48 @@ func Execute() {
 	}
 }
 
+// fromS3 splits the s3://bucket/key URL. The URL isn't parsed with net/url,
+// so a bucket glob ( see expandURLs ) and '?' or '#' in the key are taken as they are.
 func fromS3(link string) (string, string, error) {
-	u, err := url.Parse(link)
-	if err != nil {
-		return "", "", err
+	i := strings.Index(link, "://")
+	if i < 0 {
+		return "", "", fmt.Errorf("no host defined for %s", link)
+	}
+	bucket, key := link[i+len("://"):], ""
+	if j := strings.IndexByte(bucket, '/'); j >= 0 {
+		bucket, key = bucket[:j], bucket[j+1:]
 	}
-	if u.Host == "" {
+	if bucket == "" {
 		return "", "", fmt.Errorf("no host defined for %s", link)
 	}
-	return u.Host, u.Path[1:], nil
+	key, err := url.PathUnescape(key)
+	if err != nil {
+		return "", "", err
+	}
+	return bucket, key, nil
 }
 
 var globalOpts = struct {
-	workers int
-	debug   bool
-	quiet   bool
+	workers        int
+	debug          bool
+	quiet          bool
+	keepGoing      bool
+	failuresFormat string
+	retryBudget    int
+	dryRun         bool
 }{
-	workers: runtime.NumCPU(),
+	workers:        runtime.NumCPU(),
+	failuresFormat: failuresTable,
+	retryBudget:    1000,
 }
 
 func init() {
 	pf := rootCmd.PersistentFlags()
 	pf.IntVarP(&globalOpts.workers, "workers", "w", runtime.NumCPU(), "number of concurrent threads")
-	f := rootCmd.Flags()
-	f.BoolVar(&globalOpts.debug, "debug", false, "print debug messages")
-	f.BoolVar(&globalOpts.quiet, "quiet", false, "print warnings and errors")
+	pf.BoolVarP(&globalOpts.keepGoing, "keep-going", "k", false, "don't stop on per-object errors, report them at the end")
+	pf.StringVar(&globalOpts.failuresFormat, "failures-format", failuresTable, "format of the --keep-going failure report ( table | json )")
+	pf.IntVar(&globalOpts.retryBudget, "retry-budget", 1000, "total retries of requests throttled by S3, -1 for unlimited")
+	pf.BoolVar(&globalOpts.dryRun, "dry-run", false, "print the changes mutating commands would make without applying them")
+	pf.BoolVar(&globalOpts.debug, "debug", false, "print debug messages")
+	pf.BoolVar(&globalOpts.quiet, "quiet", false, "print warnings and errors")
 }
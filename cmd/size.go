@@ -1,16 +1,16 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/dustin/go-humanize"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
@@ -19,25 +19,60 @@ type pathSpec struct {
 	prefix string
 }
 type SizeSpec struct {
-	Path  string
-	Count uint64
-	Size  uint64
+	Bucket string
+	Path   string
+	Count  uint64
+	Size   uint64
+}
+
+func (s SizeSpec) rows() [][]string {
+	return [][]string{{s.Bucket, s.Path, strconv.FormatUint(s.Count, 10), formatSize(s.Size)}}
+}
+
+// formatSize formats the size for humans unless --raw is set.
+func formatSize(size uint64) string {
+	if sizeOpts.raw {
+		return strconv.FormatUint(size, 10)
+	}
+	return humanize.Bytes(size)
 }
 
 var sizeCmd = &cobra.Command{
 	Use:          "size  s3://bucket/key1 s3://bucket/prefix/ ...",
 	Short:        "Calculate size of S3 location",
+	Annotations:  map[string]string{progressAnnotation: progressStderr},
 	Args:         cobra.MinimumNArgs(1),
 	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		svc := getS3()
+		args, err := expandURLs(cmd.Context(), args)
+		if err != nil {
+			return err
+		}
+		// a failure without --keep-going cancels ctx, the listings and the workers stop
+		ctx, stop := context.WithCancel(cmd.Context())
+		defer stop()
 
 		specsChan := make(chan pathSpec, 100)
 		sizesChan := make(chan SizeSpec, 100)
 
 		sizes := make([]SizeSpec, 0, len(args))
 
-		var wg, sg sync.WaitGroup
+		var (
+			wg, sg   sync.WaitGroup
+			failed   failures
+			failOnce sync.Once
+			failErr  error
+		)
+		fail := func(bucket, prefix string, err error) {
+			if globalOpts.keepGoing {
+				failed.add(bucket, prefix, "", err)
+				return
+			}
+			failOnce.Do(func() {
+				failErr = fmt.Errorf("can't list objects at s3://%s/%s: %v", bucket, prefix, err)
+				stop()
+			})
+		}
 		sg.Add(1)
 		wg.Add(globalOpts.workers)
 
@@ -52,19 +87,27 @@ var sizeCmd = &cobra.Command{
 			go func() {
 				defer wg.Done()
 				for spec := range specsChan {
+					if ctx.Err() != nil {
+						continue
+					}
 					var ss SizeSpec
+					ss.Bucket = spec.bucket
 					ss.Path = fmt.Sprintf("s3://%s/%s", spec.bucket, spec.prefix)
-					if err := svc.ListObjectsPages(&s3.ListObjectsInput{
+					if err := walkObjects(ctx, &s3.ListObjectsV2Input{
 						Bucket: &spec.bucket,
 						Prefix: &spec.prefix,
-					}, func(res *s3.ListObjectsOutput, last bool) bool {
+					}, func(res *s3.ListObjectsV2Output, last bool) bool {
 						for _, o := range res.Contents {
 							ss.Size += uint64(*o.Size)
 						}
 						ss.Count += uint64(len(res.Contents))
 						return true
 					}); err != nil {
-						log.Fatalf("can't list objects at s3://%s/%s => %v", spec.bucket, spec.prefix, err)
+						if ctx.Err() != nil {
+							continue
+						}
+						fail(spec.bucket, spec.prefix, err)
+						continue
 					}
 					sizesChan <- ss
 				}
@@ -74,68 +117,89 @@ var sizeCmd = &cobra.Command{
 		for _, url := range args {
 			bucket, prefix, err := fromS3(url)
 			if err != nil {
-				return err
+				failOnce.Do(func() {
+					failErr = err
+					stop()
+				})
+				break
 			}
 			if sizeOpts.group {
-				if err := svc.ListObjectsPages(&s3.ListObjectsInput{
+				if err := listObjects(ctx, &s3.ListObjectsV2Input{
 					Delimiter: aws.String("/"),
 					Bucket:    &bucket,
 					Prefix:    &prefix,
-				}, func(res *s3.ListObjectsOutput, last bool) bool {
+				}, func(res *s3.ListObjectsV2Output, last bool) bool {
 					for _, pfx := range res.CommonPrefixes {
-						specsChan <- pathSpec{
+						if p := *pfx.Prefix; p < listingConf.startAfter && !strings.HasPrefix(listingConf.startAfter, p) {
+							// every key of the group is before --start-after
+							continue
+						}
+						select {
+						case specsChan <- pathSpec{
 							bucket: bucket,
 							prefix: *pfx.Prefix,
+						}:
+						case <-ctx.Done():
+							return false
 						}
 					}
 					return true
-				}); err != nil {
-					log.Fatalf("can't list objects at s3://%s/%s => %v", bucket, prefix, err)
+				}); err != nil && ctx.Err() == nil {
+					fail(bucket, prefix, err)
 				}
 			} else {
-				specsChan <- pathSpec{
+				select {
+				case specsChan <- pathSpec{
 					bucket: bucket,
 					prefix: prefix,
+				}:
+				case <-ctx.Done():
 				}
 			}
+			if ctx.Err() != nil {
+				break
+			}
 		}
 
 		close(specsChan)
 		wg.Wait()
 		close(sizesChan)
 		sg.Wait()
-		switch {
-		case sizeOpts.asJson:
-			return json.NewEncoder(os.Stdout).Encode(sizes)
-		default:
-			var hmnz func(uint64) string
-			if !sizeOpts.raw {
-				hmnz = humanize.Bytes
-			} else {
-				hmnz = func(x uint64) string {
-					return strconv.FormatUint(x, 10)
-				}
-			}
-			table := tablewriter.NewWriter(os.Stdout)
-			table.SetHeader([]string{"Path", "Count", "Size"})
-			var totalSize, totalCount uint64 = 0, 0
-			for _, size := range sizes {
-				totalSize += size.Size
-				totalCount += size.Count
-				table.Append([]string{size.Path, strconv.FormatUint(size.Count, 10), hmnz(size.Size)})
+		if failErr != nil {
+			return failErr
+		}
+		if err := ctx.Err(); err != nil {
+			log.Warnf("interrupted: size of %d location(s) calculated, nothing printed", len(sizes))
+			return err
+		}
+		stopProgress()
+		def := outputTable
+		if sizeOpts.asJson {
+			def = outputJson
+		}
+		out := newRenderer(os.Stdout, def, []string{"Bucket", "Path", "Count", "Size"})
+		var totalSize, totalCount uint64 = 0, 0
+		for _, size := range sizes {
+			totalSize += size.Size
+			totalCount += size.Count
+			if err := out.add(size); err != nil {
+				return err
 			}
-			table.SetFooter([]string{"Total:", strconv.FormatUint(totalCount, 10), hmnz(totalSize)})
-			table.Render()
 		}
-		return nil
+		out.footer([]string{"Total:", "", strconv.FormatUint(totalCount, 10), formatSize(totalSize)})
+		if err := out.close(); err != nil {
+			return err
+		}
+		return failed.report()
 	},
 }
 
 func init() {
 	pf := sizeCmd.Flags()
 	pf.BoolVarP(&sizeOpts.group, "group", "g", false, "group sizes by top-level folders")
-	pf.BoolVar(&sizeOpts.asJson, "json", false, "output as JSON array")
+	pf.BoolVar(&sizeOpts.asJson, "json", false, "output as JSON array, same as --output json")
 	pf.BoolVar(&sizeOpts.raw, "raw", false, "raw numbers, no human-formatted size")
+	initRangeFlags(pf)
 	rootCmd.AddCommand(sizeCmd)
 }
 
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/dustin/go-humanize"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/yaml.v2"
+)
+
+// latency histogram buckets grow by latencyFactor from latencyMin, the last one is unbounded.
+const (
+	latencyMin     = time.Millisecond
+	latencyFactor  = 1.25
+	latencyBuckets = 64
+)
+
+var statsConf struct {
+	enabled    bool
+	priceTable string
+	prices     map[string]float64
+}
+
+// defaultPrices are USD per 1000 requests of S3 Standard, keys are operation names or globs of them.
+var defaultPrices = map[string]float64{
+	"List*":   0.005,
+	"Put*":    0.005,
+	"Copy*":   0.005,
+	"Post*":   0.005,
+	"Create*": 0.005,
+	"Delete*": 0,
+	"*":       0.0004,
+}
+
+// opStats are the counters of an API operation.
+type opStats struct {
+	requests uint64
+	// every attempt of the requests is billed, the retries included
+	attempts  uint64
+	errors    uint64
+	retries   uint64
+	throttles uint64
+	latencies [latencyBuckets]uint64
+}
+
+// percentile returns the upper bound of the latency bucket the p-th percentile falls into.
+func (s *opStats) percentile(p float64) time.Duration {
+	rank := uint64(math.Ceil(float64(s.requests) * p / 100))
+	var seen uint64
+	for i, n := range s.latencies {
+		if seen += n; seen >= rank && n > 0 {
+			return latencyBound(i)
+		}
+	}
+	return 0
+}
+
+func latencyBound(bucket int) time.Duration {
+	return time.Duration(float64(latencyMin) * math.Pow(latencyFactor, float64(bucket)))
+}
+
+func latencyBucket(d time.Duration) int {
+	if d <= latencyMin {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(latencyMin)) / math.Log(latencyFactor)))
+	if i >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+	return i
+}
+
+// apiStats account the requests made by all S3 clients of the session.
+type apiStats struct {
+	mu sync.Mutex
+	// retries made by the limiter, they are separate requests for the SDK
+	limiterRetries uint64
+	// the bytes of the response bodies read, updated atomically
+	downloaded uint64
+	ops        map[string]*opStats
+}
+
+var apiCalls = &apiStats{ops: make(map[string]*opStats)}
+
+func (s *apiStats) op(name string) *opStats {
+	o, ok := s.ops[name]
+	if !ok {
+		o = &opStats{}
+		s.ops[name] = o
+	}
+	return o
+}
+
+// complete is a request.Handlers.Complete handler, it runs once the request and its retries are done.
+func (s *apiStats) complete(r *request.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.op(r.Operation.Name)
+	o.requests++
+	o.attempts += uint64(r.RetryCount) + 1
+	o.retries += uint64(r.RetryCount)
+	o.latencies[latencyBucket(time.Since(r.Time))]++
+	if r.Error != nil {
+		o.errors++
+	}
+}
+
+// read counts the bytes of a response body, see countingReader.
+func (s *apiStats) read(n int) {
+	atomic.AddUint64(&s.downloaded, uint64(n))
+}
+
+// retry is a request.Handlers.Retry handler, it runs after every failed attempt.
+func (s *apiStats) retry(r *request.Request) {
+	if !isThrottle(r.Error) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.op(r.Operation.Name).throttles++
+}
+
+func (s *apiStats) limiterRetry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiterRetries++
+}
+
+func (s *apiStats) addHandlers(h *request.Handlers) {
+	h.Complete.PushBack(s.complete)
+	h.Retry.PushBack(s.retry)
+}
+
+// loadPrices reads the --price-table file, a YAML or JSON map of operation names or globs
+// to USD per 1000 requests, on top of the default prices.
+func loadPrices(file string) (map[string]float64, error) {
+	prices := make(map[string]float64, len(defaultPrices))
+	for k, v := range defaultPrices {
+		prices[k] = v
+	}
+	if file == "" {
+		return prices, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var custom map[string]float64
+	if err := yaml.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("can't parse price table %s: %v", file, err)
+	}
+	for k, v := range custom {
+		if _, err := path.Match(k, ""); err != nil {
+			return nil, fmt.Errorf("invalid operation pattern '%s' in %s: %v", k, file, err)
+		}
+		prices[k] = v
+	}
+	return prices, nil
+}
+
+// priceOf returns the price of the operation, an exact name wins over the longest matching glob.
+func priceOf(prices map[string]float64, op string) float64 {
+	if p, ok := prices[op]; ok {
+		return p
+	}
+	var (
+		best  string
+		price float64
+	)
+	for pattern, p := range prices {
+		if ok, _ := path.Match(pattern, op); ok && (len(pattern) > len(best) || len(pattern) == len(best) && pattern < best) {
+			best, price = pattern, p
+		}
+	}
+	return price
+}
+
+// prepareStats loads the --price-table.
+func prepareStats() (err error) {
+	statsConf.prices, err = loadPrices(statsConf.priceTable)
+	return err
+}
+
+// report prints the requests by operation with their estimated cost.
+func (s *apiStats) report(w io.Writer, prices map[string]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.ops))
+	for name := range s.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var (
+		total opStats
+		cost  float64
+		table = tablewriter.NewWriter(w)
+	)
+	table.SetHeader([]string{"Operation", "Requests", "Errors", "Retries", "Throttles", "p50", "p90", "p99", "Cost, USD"})
+	for _, name := range names {
+		o := s.ops[name]
+		opCost := float64(o.attempts) * priceOf(prices, name) / 1000
+		total.requests += o.requests
+		total.errors += o.errors
+		total.retries += o.retries
+		total.throttles += o.throttles
+		cost += opCost
+		table.Append([]string{
+			name,
+			strconv.FormatUint(o.requests, 10),
+			strconv.FormatUint(o.errors, 10),
+			strconv.FormatUint(o.retries, 10),
+			strconv.FormatUint(o.throttles, 10),
+			o.percentile(50).String(),
+			o.percentile(90).String(),
+			o.percentile(99).String(),
+			fmt.Sprintf("%.6f", opCost),
+		})
+	}
+	table.SetFooter([]string{
+		"Total",
+		strconv.FormatUint(total.requests, 10),
+		strconv.FormatUint(total.errors, 10),
+		strconv.FormatUint(total.retries+s.limiterRetries, 10),
+		strconv.FormatUint(total.throttles, 10),
+		"", "", "",
+		fmt.Sprintf("%.6f", cost),
+	})
+	table.Render()
+	_, err := fmt.Fprintf(w, "Downloaded %s, %d throttled request(s) retried by s3kit\n", humanize.Bytes(atomic.LoadUint64(&s.downloaded)), s.limiterRetries)
+	return err
+}
+
+// printStats prints the --stats report to stderr.
+func printStats() {
+	if !statsConf.enabled {
+		return
+	}
+	if err := apiCalls.report(os.Stderr, statsConf.prices); err != nil {
+		fmt.Fprintf(os.Stderr, "can't print stats: %v\n", err)
+	}
+}
+
+func init() {
+	pf := rootCmd.PersistentFlags()
+	pf.BoolVar(&statsConf.enabled, "stats", false, "print the S3 requests made, their latency and estimated cost to stderr at exit")
+	pf.StringVar(&statsConf.priceTable, "price-table", "", "YAML or JSON map of operation names or globs to USD per 1000 requests, e.g. {GetObject: 0.0004}")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiStats(t *testing.T) {
+	s := &apiStats{ops: make(map[string]*opStats)}
+	req := func(op string, latency time.Duration, retries int, err error) *request.Request {
+		return &request.Request{
+			Operation:  &request.Operation{Name: op},
+			Time:       time.Now().Add(-latency),
+			RetryCount: retries,
+			Error:      err,
+		}
+	}
+	for i := 0; i < 99; i++ {
+		s.complete(req("GetObject", 10*time.Millisecond, 0, nil))
+	}
+	throttled := req("GetObject", time.Second, 2, awserr.New("SlowDown", "slow down", nil))
+	s.retry(throttled)
+	s.retry(req("GetObject", 0, 0, awserr.New("InternalError", "", nil)))
+	s.complete(throttled)
+	s.complete(req("ListObjectVersions", 100*time.Millisecond, 0, nil))
+	s.limiterRetry()
+	s.read(10000)
+	s.read(100)
+
+	get := s.ops["GetObject"]
+	require.Equal(t, uint64(100), get.requests)
+	require.Equal(t, uint64(1), get.errors)
+	require.Equal(t, uint64(2), get.retries)
+	require.Equal(t, uint64(102), get.attempts)
+	require.Equal(t, uint64(1), get.throttles)
+	require.Equal(t, latencyBound(latencyBucket(10*time.Millisecond)), get.percentile(50))
+	require.Equal(t, latencyBound(latencyBucket(time.Second)), get.percentile(100))
+	require.True(t, get.percentile(50) >= 10*time.Millisecond && get.percentile(50) < 13*time.Millisecond)
+	require.Equal(t, uint64(10100), s.downloaded)
+
+	var buf bytes.Buffer
+	require.NoError(t, s.report(&buf, defaultPrices))
+	require.Contains(t, buf.String(), "0.000041") // 100 GetObject, 2 of them retried
+	require.Contains(t, buf.String(), "0.000046") // and a ListObjectVersions
+	require.Contains(t, buf.String(), "Downloaded 10 kB, 1 throttled request(s) retried by s3kit")
+}
+
+func TestPrices(t *testing.T) {
+	f, err := ioutil.TempFile("", "prices")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"GetObject": 0.001, "Get*Tagging": 0.002}`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	prices, err := loadPrices(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, 0.001, priceOf(prices, "GetObject"))
+	require.Equal(t, 0.002, priceOf(prices, "GetObjectTagging"))
+	require.Equal(t, 0.0004, priceOf(prices, "HeadObject"))
+	require.Equal(t, 0.005, priceOf(prices, "ListObjectsV2"))
+	require.Equal(t, 0.0, priceOf(prices, "DeleteObject"))
+}
@@ -32,10 +32,12 @@ func getTagMap(expectValue bool) map[string]string {
 var tagAdd = &cobra.Command{
 	Use:          "add s3://bucket/folder/ s3://bucket/folder/prefix ...",
 	Short:        "Add tag(s) to S3 object(s)",
+	Annotations:  map[string]string{progressAnnotation: progressStderr},
+	Args:         urlArgs,
 	SilenceUsage: true,
-	RunE: func(_ *cobra.Command, urls []string) error {
+	RunE: func(cmd *cobra.Command, urls []string) error {
 		svc := getS3()
-		return run(urls, accessFuncBuilder(func(bucket string, o *s3.ObjectVersion) error {
+		return run(cmd.Context(), urls, accessFuncBuilder(func(bucket string, o *s3.ObjectVersion) error {
 			tagResp, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
 				Bucket:    &bucket,
 				Key:       o.Key,
@@ -45,6 +47,7 @@ var tagAdd = &cobra.Command{
 				return err
 			}
 			ts := tagResp.TagSet
+			before := describeTags(ts)
 			newTags := getTagMap(true)
 			for i := range ts {
 				if v, ok := newTags[*ts[i].Key]; ok {
@@ -58,6 +61,10 @@ var tagAdd = &cobra.Command{
 					Value: aws.String(v),
 				})
 			}
+			if globalOpts.dryRun {
+				printPlan(bucket, o, "tags", before, describeTags(ts))
+				return nil
+			}
 
 			_, err = svc.PutObjectTagging(&s3.PutObjectTaggingInput{
 				Bucket:    &bucket,
@@ -75,10 +82,12 @@ var tagAdd = &cobra.Command{
 var tagRm = &cobra.Command{
 	Use:          "rm s3://bucket/folder/ s3://bucket/folder/prefix ...",
 	Short:        "remove tag(s) from S3 object(s)",
+	Annotations:  map[string]string{progressAnnotation: progressStderr},
+	Args:         urlArgs,
 	SilenceUsage: true,
-	RunE: func(_ *cobra.Command, urls []string) error {
+	RunE: func(cmd *cobra.Command, urls []string) error {
 		svc := getS3()
-		return run(urls, accessFuncBuilder(func(bucket string, o *s3.ObjectVersion) error {
+		return run(cmd.Context(), urls, accessFuncBuilder(func(bucket string, o *s3.ObjectVersion) error {
 			tagResp, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
 				Bucket:    &bucket,
 				Key:       o.Key,
@@ -88,6 +97,7 @@ var tagRm = &cobra.Command{
 				return err
 			}
 			ts := tagResp.TagSet
+			before := describeTags(ts)
 			rmTags := getTagMap(false)
 			i := 0
 			for i < len(ts) {
@@ -99,6 +109,10 @@ var tagRm = &cobra.Command{
 				}
 				i++
 			}
+			if globalOpts.dryRun {
+				printPlan(bucket, o, "tags", before, describeTags(ts))
+				return nil
+			}
 			_, err = svc.PutObjectTagging(&s3.PutObjectTaggingInput{
 				Bucket:    &bucket,
 				Key:       o.Key,
@@ -118,6 +132,7 @@ func init() {
 	tagRm.Flags().StringSliceVar(&tagFlags.tags, "tags", nil, "tags as --tags 'tag1,tag2' or multiple --tags ... options")
 	tagRm.MarkFlagRequired("tags")
 	initVersionsConfig(tagRoot.PersistentFlags())
+	initCheckpointConfig(tagRoot.PersistentFlags())
 	tagRoot.AddCommand(tagAdd, tagRm)
 	rootCmd.AddCommand(tagRoot)
 }
@@ -5,11 +5,16 @@ go 1.14
 require (
 	github.com/aws/aws-sdk-go v1.30.7
 	github.com/dustin/go-humanize v1.0.0
+	github.com/golang/snappy v0.0.1
+	github.com/klauspost/compress v1.9.7
+	github.com/linkedin/goavro/v2 v2.9.8
 	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/olekukonko/tablewriter v0.0.5-0.20200416053754-163badb3bac6
+	github.com/pierrec/lz4 v2.5.2+incompatible
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.5.1
+	github.com/ulikunitz/xz v0.5.7
 	github.com/xitongsys/parquet-go v1.5.1
 	github.com/xitongsys/parquet-go-source v0.0.0-20200326031722-42b453e70c3b
 	go.uber.org/zap v1.10.0
@@ -18,5 +18,5 @@ func TestSimpleParser(t *testing.T) {
 		res = append(res, *r)
 		return true
 	}))
-	require.Equal(t, 10, len(res))
+	require.Equal(t, 5, len(res), "every line of the fixture is a record")
 }
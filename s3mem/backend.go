@@ -0,0 +1,611 @@
+// Package s3mem provides an in-memory implementation of the S3 API subset used by s3kit.
+package s3mem
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const defaultMaxKeys = 1000
+
+// Backend keeps buckets, object versions, tags, legal holds and retention settings in memory.
+// Only the operations used by s3kit are implemented, calling any other s3iface.S3API method panics.
+type Backend struct {
+	s3iface.S3API
+
+	// Now returns the time used as LastModified for new object versions.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	seq     int
+}
+
+type bucket struct {
+	objectLock bool
+	region     string
+	created    time.Time
+	keys       map[string][]*version // newest version first
+}
+
+type version struct {
+	id           string
+	data         []byte
+	lastModified time.Time
+	deleteMarker bool
+	tags         []*s3.Tag
+	legalHold    string
+	retention    *s3.ObjectLockRetention
+}
+
+func New() *Backend {
+	return &Backend{
+		Now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func newError(code string, status int, format string, args ...interface{}) error {
+	return awserr.NewRequestFailure(awserr.New(code, fmt.Sprintf(format, args...), nil), status, "")
+}
+
+func (b *Backend) bucket(name *string) (*bucket, error) {
+	bkt, ok := b.buckets[aws.StringValue(name)]
+	if !ok {
+		return nil, newError(s3.ErrCodeNoSuchBucket, http.StatusNotFound, "bucket %s does not exist", aws.StringValue(name))
+	}
+	return bkt, nil
+}
+
+// find returns the requested version of the key, or the latest one if versionId is empty.
+func (b *Backend) find(bucketName, key, versionId *string) (*version, error) {
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	versions := bkt.keys[aws.StringValue(key)]
+	if aws.StringValue(versionId) == "" {
+		if len(versions) == 0 || versions[0].deleteMarker {
+			return nil, newError(s3.ErrCodeNoSuchKey, http.StatusNotFound, "key %s does not exist", aws.StringValue(key))
+		}
+		return versions[0], nil
+	}
+	for _, v := range versions {
+		if v.id == *versionId {
+			return v, nil
+		}
+	}
+	return nil, newError("NoSuchVersion", http.StatusNotFound, "version %s of %s does not exist", *versionId, aws.StringValue(key))
+}
+
+// Put stores a new version of the object with the given modification time and returns its version id.
+func (b *Backend) Put(bucketName, key string, data []byte, lastModified time.Time) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bkt, err := b.bucket(&bucketName)
+	if err != nil {
+		return "", err
+	}
+	b.seq++
+	v := &version{
+		id:           fmt.Sprintf("%08d", b.seq),
+		data:         data,
+		lastModified: lastModified.UTC(),
+	}
+	bkt.keys[key] = append([]*version{v}, bkt.keys[key]...)
+	return v.id, nil
+}
+
+func (b *Backend) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	return b.CreateBucketWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) CreateBucketWithContext(_ aws.Context, input *s3.CreateBucketInput, _ ...request.Option) (*s3.CreateBucketOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	name := aws.StringValue(input.Bucket)
+	if _, ok := b.buckets[name]; ok {
+		return nil, newError(s3.ErrCodeBucketAlreadyOwnedByYou, http.StatusConflict, "bucket %s already exists", name)
+	}
+	bkt := &bucket{
+		objectLock: aws.BoolValue(input.ObjectLockEnabledForBucket),
+		created:    b.Now().UTC(),
+		keys:       make(map[string][]*version),
+	}
+	if cfg := input.CreateBucketConfiguration; cfg != nil {
+		bkt.region = aws.StringValue(cfg.LocationConstraint)
+	}
+	b.buckets[name] = bkt
+	return &s3.CreateBucketOutput{Location: aws.String("/" + name)}, nil
+}
+
+func (b *Backend) ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	return b.ListBucketsWithContext(aws.BackgroundContext(), input)
+}
+
+// ListBucketsWithContext returns the buckets sorted by name like S3.
+func (b *Backend) ListBucketsWithContext(_ aws.Context, _ *s3.ListBucketsInput, _ ...request.Option) (*s3.ListBucketsOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := &s3.ListBucketsOutput{Owner: &s3.Owner{ID: aws.String("owner")}}
+	for name, bkt := range b.buckets {
+		out.Buckets = append(out.Buckets, &s3.Bucket{
+			Name:         aws.String(name),
+			CreationDate: aws.Time(bkt.created),
+		})
+	}
+	sort.Slice(out.Buckets, func(i, j int) bool { return *out.Buckets[i].Name < *out.Buckets[j].Name })
+	return out, nil
+}
+
+func (b *Backend) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	return b.GetBucketLocationWithContext(aws.BackgroundContext(), input)
+}
+
+// GetBucketLocationWithContext returns the LocationConstraint the bucket was created with, empty for us-east-1.
+func (b *Backend) GetBucketLocationWithContext(_ aws.Context, input *s3.GetBucketLocationInput, _ ...request.Option) (*s3.GetBucketLocationOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bkt, err := b.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.GetBucketLocationOutput{}
+	if bkt.region != "" {
+		out.LocationConstraint = aws.String(bkt.region)
+	}
+	return out, nil
+}
+
+func (b *Backend) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return b.PutObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) PutObjectWithContext(_ aws.Context, input *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	var data []byte
+	if input.Body != nil {
+		var err error
+		if data, err = ioutil.ReadAll(input.Body); err != nil {
+			return nil, err
+		}
+	}
+	id, err := b.Put(aws.StringValue(input.Bucket), aws.StringValue(input.Key), data, b.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectOutput{VersionId: aws.String(id)}, nil
+}
+
+func (b *Backend) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return b.DeleteObjectWithContext(aws.BackgroundContext(), input)
+}
+
+// DeleteObjectWithContext adds a delete marker, or removes the version if VersionId is given.
+func (b *Backend) DeleteObjectWithContext(_ aws.Context, input *s3.DeleteObjectInput, _ ...request.Option) (*s3.DeleteObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bkt, err := b.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	key := aws.StringValue(input.Key)
+	if id := aws.StringValue(input.VersionId); id != "" {
+		versions := bkt.keys[key]
+		for i, v := range versions {
+			if v.id != id {
+				continue
+			}
+			if v.legalHold == s3.ObjectLockLegalHoldStatusOn || (v.retention != nil && v.retention.RetainUntilDate.After(b.Now())) {
+				return nil, newError("AccessDenied", http.StatusForbidden, "version %s of %s is locked", id, key)
+			}
+			bkt.keys[key] = append(versions[:i:i], versions[i+1:]...)
+			return &s3.DeleteObjectOutput{VersionId: aws.String(id), DeleteMarker: aws.Bool(v.deleteMarker)}, nil
+		}
+		return nil, newError("NoSuchVersion", http.StatusNotFound, "version %s of %s does not exist", id, key)
+	}
+	b.seq++
+	marker := &version{
+		id:           fmt.Sprintf("%08d", b.seq),
+		lastModified: b.Now().UTC(),
+		deleteMarker: true,
+	}
+	bkt.keys[key] = append([]*version{marker}, bkt.keys[key]...)
+	return &s3.DeleteObjectOutput{VersionId: aws.String(marker.id), DeleteMarker: aws.Bool(true)}, nil
+}
+
+func (b *Backend) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return b.GetObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.find(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if v.deleteMarker {
+		return nil, newError("MethodNotAllowed", http.StatusMethodNotAllowed, "version %s is a delete marker", v.id)
+	}
+	out := &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(v.data)),
+		ContentLength: aws.Int64(int64(len(v.data))),
+		LastModified:  aws.Time(v.lastModified),
+		VersionId:     aws.String(v.id),
+		TagCount:      aws.Int64(int64(len(v.tags))),
+	}
+	if input.Range != nil {
+		size := int64(len(v.data))
+		start, end, ok := parseRange(*input.Range, size)
+		if !ok {
+			return out, nil
+		}
+		if start >= size || start > end {
+			return nil, newError("InvalidRange", http.StatusRequestedRangeNotSatisfiable, "the range %s is not satisfiable", *input.Range)
+		}
+		out.Body = ioutil.NopCloser(bytes.NewReader(v.data[start : end+1]))
+		out.ContentLength = aws.Int64(end + 1 - start)
+		out.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+	return out, nil
+}
+
+// parseRange returns the first and the last byte of a "bytes=first-last", "bytes=first-" or "bytes=-length" range
+// of the content of the given size. Like S3 the invalid ranges are ignored.
+func parseRange(r string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(r, "bytes=")
+	i := strings.IndexByte(spec, '-')
+	if spec == r || i < 0 {
+		return 0, 0, false
+	}
+	first, last := spec[:i], spec[i+1:]
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func (b *Backend) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return b.HeadObjectWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) HeadObjectWithContext(_ aws.Context, input *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.find(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(v.data))),
+		LastModified:  aws.Time(v.lastModified),
+		VersionId:     aws.String(v.id),
+		DeleteMarker:  aws.Bool(v.deleteMarker),
+	}, nil
+}
+
+// entry is a key of a bucket with its versions, newest first.
+type entry struct {
+	key      string
+	versions []*version
+}
+
+// entries returns the keys of the bucket that match the prefix in lexicographic order.
+func (bkt *bucket) entries(prefix string) []entry {
+	res := make([]entry, 0, len(bkt.keys))
+	for k, versions := range bkt.keys {
+		if strings.HasPrefix(k, prefix) && len(versions) > 0 {
+			res = append(res, entry{key: k, versions: versions})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].key < res[j].key })
+	return res
+}
+
+// commonPrefix returns the prefix the key rolls up into with the given delimiter, if any.
+func commonPrefix(key, prefix, delimiter string) (string, bool) {
+	if delimiter == "" {
+		return "", false
+	}
+	if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+		return key[:len(prefix)+i+len(delimiter)], true
+	}
+	return "", false
+}
+
+func maxKeys(n *int64) int {
+	if n == nil || *n <= 0 || *n > defaultMaxKeys {
+		return defaultMaxKeys
+	}
+	return int(*n)
+}
+
+func (b *Backend) ListObjects(input *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	return b.ListObjectsWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) ListObjectsWithContext(ctx aws.Context, input *s3.ListObjectsInput, _ ...request.Option) (*s3.ListObjectsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bkt, err := b.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		prefix    = aws.StringValue(input.Prefix)
+		delimiter = aws.StringValue(input.Delimiter)
+		marker    = aws.StringValue(input.Marker)
+		limit     = maxKeys(input.MaxKeys)
+		out       = &s3.ListObjectsOutput{
+			Name:      input.Bucket,
+			Prefix:    input.Prefix,
+			Delimiter: input.Delimiter,
+			Marker:    input.Marker,
+			MaxKeys:   aws.Int64(int64(limit)),
+		}
+		last  string
+		count int
+	)
+	for _, e := range bkt.entries(prefix) {
+		if e.key <= marker || e.versions[0].deleteMarker {
+			continue
+		}
+		cp, rolled := commonPrefix(e.key, prefix, delimiter)
+		if rolled && (cp <= marker || cp == last) {
+			continue
+		}
+		if count == limit {
+			out.IsTruncated = aws.Bool(true)
+			if delimiter != "" {
+				out.NextMarker = aws.String(last)
+			}
+			return out, nil
+		}
+		if rolled {
+			out.CommonPrefixes = append(out.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(cp)})
+			last = cp
+		} else {
+			v := e.versions[0]
+			out.Contents = append(out.Contents, &s3.Object{
+				Key:          aws.String(e.key),
+				Size:         aws.Int64(int64(len(v.data))),
+				LastModified: aws.Time(v.lastModified),
+				StorageClass: aws.String(s3.ObjectStorageClassStandard),
+			})
+			last = e.key
+		}
+		count++
+	}
+	out.IsTruncated = aws.Bool(false)
+	return out, nil
+}
+
+func (b *Backend) ListObjectsPages(input *s3.ListObjectsInput, fn func(*s3.ListObjectsOutput, bool) bool) error {
+	return b.ListObjectsPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+func (b *Backend) ListObjectsPagesWithContext(ctx aws.Context, input *s3.ListObjectsInput, fn func(*s3.ListObjectsOutput, bool) bool, opts ...request.Option) error {
+	in := *input
+	for {
+		out, err := b.ListObjectsWithContext(ctx, &in, opts...)
+		if err != nil {
+			return err
+		}
+		last := !aws.BoolValue(out.IsTruncated)
+		if !fn(out, last) || last {
+			return nil
+		}
+		if out.NextMarker != nil {
+			in.Marker = out.NextMarker
+		} else {
+			in.Marker = out.Contents[len(out.Contents)-1].Key
+		}
+	}
+}
+
+func (b *Backend) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	return b.ListObjectsV2WithContext(aws.BackgroundContext(), input)
+}
+
+// ListObjectsV2WithContext lists like ListObjects, the continuation token is the last key or common prefix
+// of the previous page, which the real S3 keeps opaque.
+func (b *Backend) ListObjectsV2WithContext(ctx aws.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error) {
+	marker := aws.StringValue(input.StartAfter)
+	if token := aws.StringValue(input.ContinuationToken); token > marker {
+		marker = token
+	}
+	res, err := b.ListObjectsWithContext(ctx, &s3.ListObjectsInput{
+		Bucket:    input.Bucket,
+		Prefix:    input.Prefix,
+		Delimiter: input.Delimiter,
+		Marker:    aws.String(marker),
+		MaxKeys:   input.MaxKeys,
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.ListObjectsV2Output{
+		Name:              res.Name,
+		Prefix:            res.Prefix,
+		Delimiter:         res.Delimiter,
+		StartAfter:        input.StartAfter,
+		ContinuationToken: input.ContinuationToken,
+		MaxKeys:           res.MaxKeys,
+		IsTruncated:       res.IsTruncated,
+		Contents:          res.Contents,
+		CommonPrefixes:    res.CommonPrefixes,
+		KeyCount:          aws.Int64(int64(len(res.Contents) + len(res.CommonPrefixes))),
+	}
+	if aws.BoolValue(res.IsTruncated) {
+		last := aws.StringValue(res.NextMarker)
+		if last == "" {
+			last = *res.Contents[len(res.Contents)-1].Key
+		}
+		out.NextContinuationToken = aws.String(last)
+	}
+	return out, nil
+}
+
+func (b *Backend) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	return b.ListObjectsV2PagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+func (b *Backend) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	in := *input
+	for {
+		out, err := b.ListObjectsV2WithContext(ctx, &in, opts...)
+		if err != nil {
+			return err
+		}
+		last := !aws.BoolValue(out.IsTruncated)
+		if !fn(out, last) || last {
+			return nil
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+}
+
+func (b *Backend) ListObjectVersions(input *s3.ListObjectVersionsInput) (*s3.ListObjectVersionsOutput, error) {
+	return b.ListObjectVersionsWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) ListObjectVersionsWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, _ ...request.Option) (*s3.ListObjectVersionsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bkt, err := b.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		prefix        = aws.StringValue(input.Prefix)
+		delimiter     = aws.StringValue(input.Delimiter)
+		keyMarker     = aws.StringValue(input.KeyMarker)
+		versionMarker = aws.StringValue(input.VersionIdMarker)
+		limit         = maxKeys(input.MaxKeys)
+		out           = &s3.ListObjectVersionsOutput{
+			Name:            input.Bucket,
+			Prefix:          input.Prefix,
+			Delimiter:       input.Delimiter,
+			KeyMarker:       input.KeyMarker,
+			VersionIdMarker: input.VersionIdMarker,
+			MaxKeys:         aws.Int64(int64(limit)),
+		}
+		lastKey, lastVersion string
+		count                int
+	)
+	truncate := func() (*s3.ListObjectVersionsOutput, error) {
+		out.IsTruncated = aws.Bool(true)
+		out.NextKeyMarker = aws.String(lastKey)
+		if lastVersion != "" {
+			out.NextVersionIdMarker = aws.String(lastVersion)
+		}
+		return out, nil
+	}
+	for _, e := range bkt.entries(prefix) {
+		if e.key < keyMarker || (e.key == keyMarker && versionMarker == "") {
+			continue
+		}
+		cp, rolled := commonPrefix(e.key, prefix, delimiter)
+		if rolled {
+			if cp <= keyMarker || cp == lastKey {
+				continue
+			}
+			if count == limit {
+				return truncate()
+			}
+			out.CommonPrefixes = append(out.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(cp)})
+			lastKey, lastVersion = cp, ""
+			count++
+			continue
+		}
+		skip := e.key == keyMarker
+		for i, v := range e.versions {
+			if skip {
+				skip = v.id != versionMarker
+				continue
+			}
+			if count == limit {
+				return truncate()
+			}
+			if v.deleteMarker {
+				out.DeleteMarkers = append(out.DeleteMarkers, &s3.DeleteMarkerEntry{
+					Key:          aws.String(e.key),
+					VersionId:    aws.String(v.id),
+					IsLatest:     aws.Bool(i == 0),
+					LastModified: aws.Time(v.lastModified),
+				})
+			} else {
+				out.Versions = append(out.Versions, &s3.ObjectVersion{
+					Key:          aws.String(e.key),
+					VersionId:    aws.String(v.id),
+					IsLatest:     aws.Bool(i == 0),
+					LastModified: aws.Time(v.lastModified),
+					Size:         aws.Int64(int64(len(v.data))),
+					StorageClass: aws.String(s3.ObjectVersionStorageClassStandard),
+				})
+			}
+			lastKey, lastVersion = e.key, v.id
+			count++
+		}
+	}
+	out.IsTruncated = aws.Bool(false)
+	return out, nil
+}
+
+func (b *Backend) ListObjectVersionsPages(input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool) error {
+	return b.ListObjectVersionsPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+func (b *Backend) ListObjectVersionsPagesWithContext(ctx aws.Context, input *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, opts ...request.Option) error {
+	in := *input
+	for {
+		out, err := b.ListObjectVersionsWithContext(ctx, &in, opts...)
+		if err != nil {
+			return err
+		}
+		last := !aws.BoolValue(out.IsTruncated)
+		if !fn(out, last) || last {
+			return nil
+		}
+		in.KeyMarker, in.VersionIdMarker = out.NextKeyMarker, out.NextVersionIdMarker
+	}
+}
@@ -0,0 +1,156 @@
+package s3mem
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func newBackend(t *testing.T, objectLock bool) *Backend {
+	b := New()
+	_, err := b.CreateBucket(&s3.CreateBucketInput{
+		Bucket:                     aws.String("bucket"),
+		ObjectLockEnabledForBucket: aws.Bool(objectLock),
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func TestListObjectVersionsPages(t *testing.T) {
+	b := newBackend(t, false)
+	now := time.Now()
+	for _, k := range []string{"a/1", "a/2", "b/1", "c"} {
+		for i := 0; i < 3; i++ {
+			_, err := b.Put("bucket", k, []byte(k), now.Add(time.Duration(i)*time.Minute))
+			require.NoError(t, err)
+		}
+	}
+	_, err := b.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("c")})
+	require.NoError(t, err)
+
+	var (
+		versions, markers, pages int
+		latest                   []string
+	)
+	require.NoError(t, b.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket:  aws.String("bucket"),
+		MaxKeys: aws.Int64(2),
+	}, func(res *s3.ListObjectVersionsOutput, last bool) bool {
+		pages++
+		versions += len(res.Versions)
+		markers += len(res.DeleteMarkers)
+		for _, v := range res.Versions {
+			if *v.IsLatest {
+				latest = append(latest, *v.Key)
+			}
+		}
+		return true
+	}))
+	require.Equal(t, 12, versions)
+	require.Equal(t, 1, markers)
+	require.Equal(t, 7, pages)
+	require.Equal(t, []string{"a/1", "a/2", "b/1"}, latest)
+
+	var prefixes, keys []string
+	require.NoError(t, b.ListObjectsPages(&s3.ListObjectsInput{
+		Bucket:    aws.String("bucket"),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(1),
+	}, func(res *s3.ListObjectsOutput, last bool) bool {
+		for _, p := range res.CommonPrefixes {
+			prefixes = append(prefixes, *p.Prefix)
+		}
+		for _, o := range res.Contents {
+			keys = append(keys, *o.Key)
+		}
+		return true
+	}))
+	require.Equal(t, []string{"a/", "b/"}, prefixes)
+	require.Empty(t, keys, "deleted key must not be listed")
+
+	keys, pages = nil, 0
+	require.NoError(t, b.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:     aws.String("bucket"),
+		StartAfter: aws.String("a/1"),
+		MaxKeys:    aws.Int64(1),
+	}, func(res *s3.ListObjectsV2Output, last bool) bool {
+		pages++
+		for _, o := range res.Contents {
+			keys = append(keys, *o.Key)
+		}
+		return true
+	}))
+	require.Equal(t, []string{"a/2", "b/1"}, keys)
+	require.Equal(t, 2, pages)
+}
+
+func TestRetention(t *testing.T) {
+	b := newBackend(t, true)
+	now := time.Now()
+	b.Now = func() time.Time { return now }
+	id, err := b.Put("bucket", "key", []byte("data"), now)
+	require.NoError(t, err)
+
+	retain := func(mode string, until time.Time, bypass bool) error {
+		_, err := b.PutObjectRetention(&s3.PutObjectRetentionInput{
+			Bucket:    aws.String("bucket"),
+			Key:       aws.String("key"),
+			VersionId: aws.String(id),
+			Retention: &s3.ObjectLockRetention{
+				Mode:            aws.String(mode),
+				RetainUntilDate: aws.Time(until),
+			},
+			BypassGovernanceRetention: aws.Bool(bypass),
+		})
+		return err
+	}
+	require.NoError(t, retain(s3.ObjectLockRetentionModeGovernance, now.Add(time.Hour), false))
+	require.Error(t, retain(s3.ObjectLockRetentionModeGovernance, now.Add(time.Minute), false))
+	require.NoError(t, retain(s3.ObjectLockRetentionModeGovernance, now.Add(time.Minute), true))
+	require.NoError(t, retain(s3.ObjectLockRetentionModeCompliance, now.Add(time.Hour), false))
+	require.Error(t, retain(s3.ObjectLockRetentionModeGovernance, now.Add(time.Hour), true))
+
+	_, err = b.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{Bucket: aws.String("bucket"), Key: aws.String("key")})
+	require.Error(t, err)
+	require.Equal(t, "NoSuchObjectLockConfiguration", err.(awserr.Error).Code())
+}
+
+func TestGetObjectRange(t *testing.T) {
+	b := newBackend(t, false)
+	_, err := b.Put("bucket", "key", []byte("0123456789"), time.Now())
+	require.NoError(t, err)
+	_, err = b.Put("bucket", "empty", nil, time.Now())
+	require.NoError(t, err)
+
+	get := func(key, r string) (string, string, error) {
+		res, err := b.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key), Range: aws.String(r)})
+		if err != nil {
+			return "", "", err
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(data), aws.StringValue(res.ContentRange), nil
+	}
+	for _, tc := range []struct{ r, data, contentRange string }{
+		{"bytes=2-4", "234", "bytes 2-4/10"},
+		{"bytes=8-", "89", "bytes 8-9/10"},
+		{"bytes=-3", "789", "bytes 7-9/10"},
+		{"bytes=5-100", "56789", "bytes 5-9/10"},
+		{"bytes=-100", "0123456789", "bytes 0-9/10"},
+		{"items=1-2", "0123456789", ""},
+	} {
+		data, contentRange, err := get("key", tc.r)
+		require.NoError(t, err, tc.r)
+		require.Equal(t, tc.data, data, tc.r)
+		require.Equal(t, tc.contentRange, contentRange, tc.r)
+	}
+	_, _, err = get("key", "bytes=10-")
+	require.Equal(t, "InvalidRange", err.(awserr.Error).Code())
+	_, _, err = get("empty", "bytes=0-15")
+	require.Equal(t, "InvalidRange", err.(awserr.Error).Code())
+}
@@ -0,0 +1,153 @@
+package s3mem
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func (b *Backend) GetObjectTagging(input *s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error) {
+	return b.GetObjectTaggingWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) GetObjectTaggingWithContext(_ aws.Context, input *s3.GetObjectTaggingInput, _ ...request.Option) (*s3.GetObjectTaggingOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.find(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]*s3.Tag, len(v.tags))
+	for i, t := range v.tags {
+		tags[i] = &s3.Tag{Key: aws.String(*t.Key), Value: aws.String(*t.Value)}
+	}
+	return &s3.GetObjectTaggingOutput{TagSet: tags, VersionId: aws.String(v.id)}, nil
+}
+
+func (b *Backend) PutObjectTagging(input *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+	return b.PutObjectTaggingWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) PutObjectTaggingWithContext(_ aws.Context, input *s3.PutObjectTaggingInput, _ ...request.Option) (*s3.PutObjectTaggingOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.find(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	var tags []*s3.Tag
+	if input.Tagging != nil {
+		seen := make(map[string]bool)
+		for _, t := range input.Tagging.TagSet {
+			if seen[aws.StringValue(t.Key)] {
+				return nil, newError("InvalidTag", http.StatusBadRequest, "duplicate tag key %s", aws.StringValue(t.Key))
+			}
+			seen[aws.StringValue(t.Key)] = true
+			tags = append(tags, &s3.Tag{Key: aws.String(aws.StringValue(t.Key)), Value: aws.String(aws.StringValue(t.Value))})
+		}
+	}
+	v.tags = tags
+	return &s3.PutObjectTaggingOutput{VersionId: aws.String(v.id)}, nil
+}
+
+// lockable returns the version if its bucket has object lock enabled.
+func (b *Backend) lockable(bucketName, key, versionId *string) (*version, error) {
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if !bkt.objectLock {
+		return nil, newError("InvalidRequest", http.StatusBadRequest, "bucket is missing Object Lock Configuration")
+	}
+	return b.find(bucketName, key, versionId)
+}
+
+func (b *Backend) GetObjectLegalHold(input *s3.GetObjectLegalHoldInput) (*s3.GetObjectLegalHoldOutput, error) {
+	return b.GetObjectLegalHoldWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) GetObjectLegalHoldWithContext(_ aws.Context, input *s3.GetObjectLegalHoldInput, _ ...request.Option) (*s3.GetObjectLegalHoldOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.lockable(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if v.legalHold == "" {
+		return nil, newError("NoSuchObjectLockConfiguration", http.StatusNotFound, "the specified object does not have a legal hold configuration")
+	}
+	return &s3.GetObjectLegalHoldOutput{LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(v.legalHold)}}, nil
+}
+
+func (b *Backend) PutObjectLegalHold(input *s3.PutObjectLegalHoldInput) (*s3.PutObjectLegalHoldOutput, error) {
+	return b.PutObjectLegalHoldWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) PutObjectLegalHoldWithContext(_ aws.Context, input *s3.PutObjectLegalHoldInput, _ ...request.Option) (*s3.PutObjectLegalHoldOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.lockable(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if input.LegalHold == nil {
+		return nil, newError("MalformedXML", http.StatusBadRequest, "legal hold status is missing")
+	}
+	v.legalHold = aws.StringValue(input.LegalHold.Status)
+	return &s3.PutObjectLegalHoldOutput{}, nil
+}
+
+func (b *Backend) GetObjectRetention(input *s3.GetObjectRetentionInput) (*s3.GetObjectRetentionOutput, error) {
+	return b.GetObjectRetentionWithContext(aws.BackgroundContext(), input)
+}
+
+func (b *Backend) GetObjectRetentionWithContext(_ aws.Context, input *s3.GetObjectRetentionInput, _ ...request.Option) (*s3.GetObjectRetentionOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.lockable(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if v.retention == nil {
+		return nil, newError("NoSuchObjectLockConfiguration", http.StatusNotFound, "the specified object does not have a retention configuration")
+	}
+	return &s3.GetObjectRetentionOutput{Retention: &s3.ObjectLockRetention{
+		Mode:            aws.String(*v.retention.Mode),
+		RetainUntilDate: aws.Time(*v.retention.RetainUntilDate),
+	}}, nil
+}
+
+func (b *Backend) PutObjectRetention(input *s3.PutObjectRetentionInput) (*s3.PutObjectRetentionOutput, error) {
+	return b.PutObjectRetentionWithContext(aws.BackgroundContext(), input)
+}
+
+// PutObjectRetentionWithContext refuses to shorten an active compliance retention, and an active
+// governance retention unless BypassGovernanceRetention is set.
+func (b *Backend) PutObjectRetentionWithContext(_ aws.Context, input *s3.PutObjectRetentionInput, _ ...request.Option) (*s3.PutObjectRetentionOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, err := b.lockable(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if input.Retention == nil || input.Retention.Mode == nil || input.Retention.RetainUntilDate == nil {
+		return nil, newError("MalformedXML", http.StatusBadRequest, "retention mode and date are required")
+	}
+	if cur := v.retention; cur != nil && cur.RetainUntilDate.After(b.Now()) {
+		weaker := input.Retention.RetainUntilDate.Before(*cur.RetainUntilDate) ||
+			(*cur.Mode == s3.ObjectLockRetentionModeCompliance && *input.Retention.Mode != *cur.Mode)
+		switch {
+		case weaker && *cur.Mode == s3.ObjectLockRetentionModeCompliance:
+			return nil, newError("AccessDenied", http.StatusForbidden, "compliance retention can't be shortened")
+		case weaker && !aws.BoolValue(input.BypassGovernanceRetention):
+			return nil, newError("AccessDenied", http.StatusForbidden, "governance retention can't be shortened without bypass")
+		}
+	}
+	v.retention = &s3.ObjectLockRetention{
+		Mode:            aws.String(*input.Retention.Mode),
+		RetainUntilDate: aws.Time(input.Retention.RetainUntilDate.UTC()),
+	}
+	return &s3.PutObjectRetentionOutput{}, nil
+}